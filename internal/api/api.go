@@ -2,143 +2,452 @@ package api
 
 import (
 	"bytes"
-	"encoding/json"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"os"
+	"net/url"
 	"regexp"
-	"slices"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/killuox/koi/internal/config"
+	"github.com/killuox/koi/internal/interpolate"
 	"github.com/killuox/koi/internal/shared"
-	"github.com/killuox/koi/internal/utils"
 	"github.com/killuox/koi/internal/variables"
 )
 
 type Result struct {
 	Status   int
+	Headers  http.Header
 	Body     []byte
 	Url      string
 	Method   string
+	Env      string
 	Duration time.Duration
+	// Responses that redirected the request, in order
+	Redirects []Redirect
+	// Negotiated TLS connection, nil for plain HTTP
+	TLS *tls.ConnectionState
+	// Cookies set by the response and the redirects before it
+	Cookies []*http.Cookie
 }
 
 type UrlConfig struct {
 	Url string
 }
 
-var validMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE"}
-
 func Call(e config.Endpoint, s *shared.State) (r Result, err error) {
-	if !slices.Contains(validMethods, e.Method) {
-		return Result{}, fmt.Errorf("invalid method: %s", e.Method)
-	} else {
-		url := configureUrl(e, s)
-		return doRequest(url, e, s)
+	req, auth, send, err := newRequest(e, s, true)
+	if err != nil {
+		return Result{}, err
 	}
+	return doRequest(req, auth, send, e, s)
 }
 
-func doRequest(url string, e config.Endpoint, s *shared.State) (Result, error) {
-	var body io.Reader
+// NewRequest builds the request an endpoint sends, with every placeholder,
+// flag, env and faker value resolved. It is only built to be shown: the
+// cookies of the jar are added and oauth2 tokens are not fetched
+func NewRequest(e config.Endpoint, s *shared.State) (*http.Request, error) {
+	req, _, _, err := newRequest(e, s, false)
+	return req, err
+}
+
+// newRequest builds the request of an endpoint, sending tells whether it is
+// sent or only shown. The auth and sender it resolved are returned to send it
+func newRequest(e config.Endpoint, s *shared.State, sending bool) (*http.Request, config.Auth, sender, error) {
+	if !config.ValidMethod(e.Method) {
+		return nil, config.Auth{}, nil, fmt.Errorf("invalid method: %s", e.Method)
+	}
+
+	e, s, err := interpolateRequest(e, s)
+	if err != nil {
+		return nil, config.Auth{}, nil, err
+	}
+
+	// A --data body replaces the body parameters, unless it is merged over them
+	if s.Data != nil && !s.MergeData {
+		e.Parameters = withoutBody(e)
+	}
 
-	// Get parameters values for payload
-	if e.Method == http.MethodPost || e.Method == http.MethodPut || e.Method == http.MethodPatch {
-		payload := map[string]any{}
-		setPayload(e, payload, s)
-		jsonData, err := json.Marshal(payload)
+	params, err := resolveParams(e, s.Flags, !s.NoValidate)
+	if err != nil {
+		return nil, config.Auth{}, nil, err
+	}
+
+	// With a http+unix:// base URL the requests go to http://localhost through the socket
+	baseURL, socket, isUnix := s.Cfg.API.BaseURL, "", false
+	if socket, isUnix = strings.CutPrefix(baseURL, config.UnixScheme); isUnix {
+		baseURL = "http://localhost"
+	}
+
+	url, err := buildURL(baseURL, e, params)
+	if err != nil {
+		return nil, config.Auth{}, nil, err
+	}
+
+	var body io.Reader
+	var contentType string
+	if e.HasBody() || len(params.body) > 0 || s.Data != nil {
+		data, ct, err := requestBody(e, s, params.body)
 		if err != nil {
-			return Result{}, fmt.Errorf("error encoding JSON: %w", err)
+			return nil, config.Auth{}, nil, err
 		}
-		body = bytes.NewBuffer(jsonData)
+		body, contentType = bytes.NewReader(data), ct
 	}
 
 	// Build request
 	req, err := http.NewRequest(e.Method, url, body)
 	if err != nil {
-		return Result{}, err
+		return nil, config.Auth{}, nil, err
+	}
+	if isUnix {
+		req = withUnixSocket(req, socket)
 	}
+	req = withUploads(req, params.body)
 
-	// Set headers
+	// Set headers, the endpoint ones override the api ones
 	for key, val := range s.Cfg.API.Headers {
 		req.Header.Set(key, val)
 	}
+	for key, val := range e.Headers {
+		req.Header.Set(key, val)
+	}
+	for _, p := range params.header {
+		req.Header.Set(p.name, fmt.Sprintf("%v", p.value))
+	}
+	for _, p := range params.cookie {
+		req.AddCookie(&http.Cookie{Name: p.name, Value: fmt.Sprintf("%v", p.value)})
+	}
 
-	// Send
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return Result{}, err
+	// The configured Content-Type wins, except for multipart which needs its boundary
+	if contentType != "" && (req.Header.Get("Content-Type") == "" || strings.HasPrefix(contentType, "multipart/")) {
+		req.Header.Set("Content-Type", contentType)
 	}
-	defer resp.Body.Close()
 
-	respBody, err := io.ReadAll(resp.Body)
+	// Auth comes last, so hmac and aws-sigv4 sign the final headers and body
+	auth, err := resolveAuth(e, s)
 	if err != nil {
-		return Result{}, err
+		return nil, config.Auth{}, nil, err
+	}
+	// Auth types with a nil sender make do without the network, such as
+	// oauth2 which uses a placeholder when no token is cached
+	var send sender
+	if sending {
+		if send, err = newSender(e, s); err != nil {
+			return nil, config.Auth{}, nil, err
+		}
+	}
+	if err := applyAuth(req, auth, send); err != nil {
+		return nil, config.Auth{}, nil, err
 	}
 
-	if e.SetVariables.Body != nil {
-		// Unmarshal response body into a generic map
-		var respMap map[string]any
-		if err := json.Unmarshal(respBody, &respMap); err != nil {
-			return Result{}, fmt.Errorf("failed to unmarshal response: %w", err)
+	// The client adds the cookies of the jar when sending, after the auth
+	if !sending && s.Jar != nil && e.UsesCookies() {
+		for _, c := range s.Jar.Cookies(req.URL) {
+			req.AddCookie(c)
 		}
-		for varName, sourceName := range e.SetVariables.Body {
-			source, ok := sourceName.(string)
-			if !ok {
-				continue // skip invalid config
+	}
+
+	return req, auth, send, nil
+}
+
+type paramValue struct {
+	name  string
+	value any
+	param config.Parameter
+}
+
+// requestParams holds the resolved parameter values by location, sorted by name
+type requestParams struct {
+	path   []paramValue
+	query  []paramValue
+	header []paramValue
+	cookie []paramValue
+	body   []paramValue
+}
+
+// resolveParams resolves every parameter from flags, env, faker or defaults,
+// converts it to its type and sorts them by the location they are sent to.
+// Unset optional parameters are left out, unless they are send-null ones.
+// Every invalid value is reported at once, unless validate is false
+func resolveParams(e config.Endpoint, flags map[string]any, validate bool) (requestParams, error) {
+	params := requestParams{}
+	var violations []config.Violation
+
+	names := make([]string, 0, len(e.Parameters))
+	for name := range e.Parameters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		p := e.Parameters[name]
+		in := p.Location(e)
+
+		val, err := p.GetValue(flags, name, e)
+		if errors.Is(err, config.ErrNoValue) && p.SendNull && !p.Required {
+			val, err = nil, nil
+		}
+		if err != nil {
+			if errors.Is(err, config.ErrNoValue) && (p.Required || in == "path") {
+				violations = append(violations, config.Violation{Field: name, Message: "is required"})
+			} else if !errors.Is(err, config.ErrNoValue) {
+				violations = append(violations, config.Violation{Field: name, Message: err.Error()})
 			}
+			continue
+		}
 
-			// Navigate response JSON using dot notation path
-			if val, found := utils.DeepGet(respMap, source); found {
-				variables.SetUserVariable(varName, val)
+		// Only a body can hold a null, elsewhere it leaves the parameter out
+		if val == nil && in != "body" {
+			if in == "path" {
+				violations = append(violations, config.Violation{Field: name, Message: "cannot be null"})
 			}
+			continue
+		}
+
+		// With --no-validate values are still converted, and sent as is when they cannot be
+		coerced, invalid := p.Coerce(name, val)
+		if len(invalid) == 0 || !validate {
+			val = coerced
+		}
+		if validate {
+			violations = append(violations, invalid...)
+		}
+
+		pv := paramValue{name: name, value: val, param: p}
+		switch in {
+		case "path":
+			params.path = append(params.path, pv)
+		case "query":
+			params.query = append(params.query, pv)
+		case "header":
+			params.header = append(params.header, pv)
+		case "cookie":
+			params.cookie = append(params.cookie, pv)
+		case "body":
+			params.body = append(params.body, pv)
 		}
 	}
 
-	return Result{
-		Body:   respBody,
-		Url:    url,
-		Status: resp.StatusCode,
-		Method: e.Method,
-	}, nil
+	if len(violations) > 0 {
+		return params, ValidationError{Violations: violations}
+	}
+	return params, nil
 }
 
-func setPayload(e config.Endpoint, p map[string]any, s *shared.State) {
-	for k, param := range e.Parameters {
-		val, err := param.GetValue(s.Flags, k, e)
-		if err != nil && param.Required {
-			fmt.Printf("%s\n", err)
-			os.Exit(1)
+// withoutBody returns the parameters of an endpoint that are not sent in the body
+func withoutBody(e config.Endpoint) map[string]config.Parameter {
+	params := make(map[string]config.Parameter, len(e.Parameters))
+	for name, p := range e.Parameters {
+		if p.Location(e) != "body" {
+			params[name] = p
 		}
-		p[k] = val
 	}
+	return params
+}
+
+// ValidationError lists the invalid parameters of a request
+type ValidationError struct {
+	Violations []config.Violation
 }
 
-func configureUrl(e config.Endpoint, s *shared.State) string {
+func (e ValidationError) Error() string {
+	var sb strings.Builder
+	sb.WriteString("invalid parameters:")
+
+	// One line per parameter, in the order they were found
+	var fields []string
+	messages := map[string][]string{}
+	for _, v := range e.Violations {
+		if _, seen := messages[v.Field]; !seen {
+			fields = append(fields, v.Field)
+		}
+		messages[v.Field] = append(messages[v.Field], v.Message)
+	}
+	for _, field := range fields {
+		fmt.Fprintf(&sb, "\n  - %s: %s", field, strings.Join(messages[field], ", "))
+	}
+	return sb.String()
+}
+
+// buildURL fills the path placeholders with escaped values and appends the
+// encoded query parameters
+func buildURL(baseURL string, e config.Endpoint, params requestParams) (string, error) {
 	path := e.Path
-	var queryCount int
-	var re = regexp.MustCompile(`\{[^}]+\}`) // Check if {anything}
-	if re.MatchString(path) {
-		// Check if we need to replace a dynamic path parameters
-		for k, p := range e.Parameters {
-			val, err := p.GetValue(s.Flags, k, e)
-			if err != nil && p.Required {
-				fmt.Printf("%s\n", err)
-				os.Exit(1)
-			}
-			switch p.In {
-			case "path":
-				path = strings.ReplaceAll(path, fmt.Sprintf("{%s}", k), fmt.Sprintf("%v", val))
-			case "query":
-				sep := "?"
-				if queryCount > 0 {
-					sep = "&"
-				}
-				path += fmt.Sprintf("%s%s=%s", sep, k, val)
-			}
+	for _, p := range params.path {
+		path = strings.ReplaceAll(path, "{"+p.name+"}", url.PathEscape(fmt.Sprintf("%v", p.value)))
+	}
+	if m := pathPlaceholderRe.FindString(path); m != "" {
+		return "", fmt.Errorf("no path parameter defined for %s in %s", m, e.Path)
+	}
+
+	query := url.Values{}
+	for _, p := range params.query {
+		addQuery(query, p.name, p.value, p.param.Style)
+	}
+
+	u := baseURL + path
+	if len(query) > 0 {
+		sep := "?"
+		if strings.Contains(u, "?") {
+			sep = "&"
+		}
+		u += sep + query.Encode()
+	}
+	return u, nil
+}
+
+var pathPlaceholderRe = regexp.MustCompile(`\{[^}]+\}`)
+
+// addQuery adds a query value. Lists are sent as repeated keys (tag=a&tag=b)
+// by default, joined with commas (tag=a,b) or as brackets (tag[]=a&tag[]=b)
+func addQuery(query url.Values, key string, val any, style string) {
+	list, isList := val.([]any)
+	if !isList {
+		query.Add(key, fmt.Sprintf("%v", val))
+		return
+	}
+
+	items := make([]string, len(list))
+	for i, item := range list {
+		items[i] = fmt.Sprintf("%v", item)
+	}
+	switch style {
+	case "comma":
+		query.Add(key, strings.Join(items, ","))
+	case "brackets":
+		for _, item := range items {
+			query.Add(key+"[]", item)
+		}
+	default:
+		for _, item := range items {
+			query.Add(key, item)
 		}
 	}
-	return s.Cfg.API.BaseURL + path
+}
+
+// interpolateRequest resolves the {{placeholders}} of everything sent with the request
+func interpolateRequest(e config.Endpoint, s *shared.State) (config.Endpoint, *shared.State, error) {
+	r := interpolate.New(s.Variables)
+
+	e.Path = r.String(e.Path)
+	e.Defaults = r.Map(e.Defaults)
+	e.Headers = r.StringMap(e.Headers)
+
+	resolved := *s
+	resolved.Flags = r.Map(s.Flags)
+	resolved.Cfg.API.Headers = r.StringMap(s.Cfg.API.Headers)
+
+	if err := r.Err(); err != nil {
+		return e, s, err
+	}
+	return e, &resolved, nil
+}
+
+// newSender sends requests with the client and TLS settings of an endpoint
+func newSender(e config.Endpoint, s *shared.State) (sender, error) {
+	client, tlsConfig := s.Cfg.API.Client.Merge(e.Client), s.Cfg.API.TLS.Merge(e.TLS)
+
+	r := interpolate.New(s.Variables)
+	tlsConfig.Password = r.String(tlsConfig.Password)
+	if err := r.Err(); err != nil {
+		return nil, err
+	}
+
+	// Token requests share the jar too, some identity providers keep a session
+	if s.Jar == nil || !e.UsesCookies() {
+		return func(req *http.Request) (Result, error) {
+			return Send(req, client, tlsConfig, nil)
+		}, nil
+	}
+	return func(req *http.Request) (Result, error) {
+		result, err := Send(req, client, tlsConfig, s.Jar)
+		if err != nil {
+			return result, err
+		}
+		if err := s.Jar.Save(); err != nil {
+			return result, fmt.Errorf("error saving cookies: %w", err)
+		}
+		return result, nil
+	}, nil
+}
+
+func doRequest(req *http.Request, auth config.Auth, send sender, e config.Endpoint, s *shared.State) (Result, error) {
+	result, err := send(req)
+	if err != nil {
+		return Result{}, err
+	}
+
+	// Auth types such as digest answer the challenge of a 401 response, and
+	// oauth2 renews its token, the request is retried once
+	retry, err := retryAuth(req, &result, auth, send)
+	if err != nil {
+		return Result{}, err
+	}
+	if retry != nil {
+		if result, err = send(retry); err != nil {
+			return Result{}, err
+		}
+	}
+	result.Env = s.Cfg.ActiveEnv
+
+	captured, err := captureVariables(e.SetVariables, result)
+	if err != nil {
+		return Result{}, err
+	}
+	for name, val := range captured {
+		if err := variables.SetUserVariable(name, val); err != nil {
+			return Result{}, err
+		}
+	}
+
+	return result, nil
+}
+
+// Send sends a request with the client and TLS settings and reads the whole
+// response. The cookies of the jar are sent and updated, unless it is nil
+func Send(req *http.Request, c config.Client, t config.TLS, jar http.CookieJar) (Result, error) {
+	var redirects []Redirect
+	client, err := newClient(c, t, &redirects)
+	if err != nil {
+		return Result{}, err
+	}
+	client.Jar = jar
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return Result{}, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Result{}, err
+	}
+
+	// The URL of the last response, after redirects
+	url := resp.Request.URL.String()
+	if socket, ok := UnixSocket(req); ok {
+		url = config.UnixScheme + socket + resp.Request.URL.RequestURI()
+	}
+
+	var cookies []*http.Cookie
+	for _, r := range redirects {
+		cookies = append(cookies, r.Cookies...)
+	}
+	cookies = append(cookies, resp.Cookies()...)
+
+	return Result{
+		Body:      respBody,
+		Url:       url,
+		Status:    resp.StatusCode,
+		Headers:   resp.Header,
+		Method:    req.Method,
+		Redirects: redirects,
+		TLS:       resp.TLS,
+		Cookies:   cookies,
+	}, nil
 }
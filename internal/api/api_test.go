@@ -0,0 +1,245 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/killuox/koi/internal/config"
+	"github.com/killuox/koi/internal/shared"
+)
+
+// received is a request as the test server saw it
+type received struct {
+	uri     string
+	query   string
+	headers http.Header
+	cookies []*http.Cookie
+	body    string
+}
+
+// newRecorder starts a server recording the last request it receives
+func newRecorder(t *testing.T) (*httptest.Server, *received) {
+	t.Helper()
+	last := &received{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		*last = received{
+			uri:     r.RequestURI,
+			query:   r.URL.RawQuery,
+			headers: r.Header,
+			cookies: r.Cookies(),
+			body:    string(body),
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, last
+}
+
+func TestNewRequestParameters(t *testing.T) {
+	srv, last := newRecorder(t)
+
+	tests := []struct {
+		name     string
+		endpoint config.Endpoint
+		flags    map[string]any
+		wantURI  string
+		check    func(t *testing.T, r *received)
+	}{
+		{
+			name: "path values are escaped",
+			endpoint: config.Endpoint{Method: "GET", Path: "/users/{id}", Parameters: map[string]config.Parameter{
+				"id": {Type: "string", In: "path"},
+			}},
+			flags:   map[string]any{"id": "a b/c"},
+			wantURI: "/users/a%20b%2Fc",
+		},
+		{
+			name: "query values are encoded",
+			endpoint: config.Endpoint{Method: "GET", Path: "/search", Parameters: map[string]config.Parameter{
+				"q": {Type: "string", In: "query"},
+			}},
+			flags:   map[string]any{"q": "x&y=z"},
+			wantURI: "/search?q=x%26y%3Dz",
+		},
+		{
+			name: "lists repeat their key by default",
+			endpoint: config.Endpoint{Method: "GET", Path: "/items", Parameters: map[string]config.Parameter{
+				"tag": {Type: "array", In: "query", Items: &config.Parameter{Type: "string"}},
+			}},
+			flags:   map[string]any{"tag": []any{"a", "b"}},
+			wantURI: "/items?tag=a&tag=b",
+		},
+		{
+			name: "comma style joins the list",
+			endpoint: config.Endpoint{Method: "GET", Path: "/items", Parameters: map[string]config.Parameter{
+				"tag": {Type: "array", In: "query", Style: "comma", Items: &config.Parameter{Type: "string"}},
+			}},
+			flags:   map[string]any{"tag": []any{"a", "b"}},
+			wantURI: "/items?tag=a%2Cb",
+		},
+		{
+			name: "brackets style suffixes the key",
+			endpoint: config.Endpoint{Method: "GET", Path: "/items", Parameters: map[string]config.Parameter{
+				"tag": {Type: "array", In: "query", Style: "brackets", Items: &config.Parameter{Type: "string"}},
+			}},
+			flags:   map[string]any{"tag": []any{"a", "b"}},
+			wantURI: "/items?tag%5B%5D=a&tag%5B%5D=b",
+		},
+		{
+			name: "parameters are joined with &",
+			endpoint: config.Endpoint{Method: "GET", Path: "/items", Parameters: map[string]config.Parameter{
+				"page":  {Type: "int", In: "query"},
+				"limit": {Type: "int", In: "query"},
+			}},
+			flags:   map[string]any{"page": 2, "limit": 10},
+			wantURI: "/items?limit=10&page=2",
+		},
+		{
+			name: "parameters are appended to a query in the path",
+			endpoint: config.Endpoint{Method: "GET", Path: "/items?v=1", Parameters: map[string]config.Parameter{
+				"page": {Type: "int", In: "query"},
+			}},
+			flags:   map[string]any{"page": 2},
+			wantURI: "/items?v=1&page=2",
+		},
+		{
+			name: "parameters are only sent in their location",
+			endpoint: config.Endpoint{Method: "POST", Path: "/users/{id}", Parameters: map[string]config.Parameter{
+				"id":      {Type: "string", In: "path"},
+				"q":       {Type: "string", In: "query"},
+				"X-Trace": {Type: "string", In: "header"},
+				"session": {Type: "string", In: "cookie"},
+				"name":    {Type: "string", In: "body"},
+			}},
+			flags: map[string]any{
+				"id": "u1", "q": "find", "X-Trace": "t1", "session": "s1", "name": "koi",
+			},
+			wantURI: "/users/u1?q=find",
+			check: func(t *testing.T, r *received) {
+				if got := r.headers.Get("X-Trace"); got != "t1" {
+					t.Errorf("X-Trace header = %q, want t1", got)
+				}
+				if len(r.cookies) != 1 || r.cookies[0].Name != "session" || r.cookies[0].Value != "s1" {
+					t.Errorf("cookies = %v, want session=s1", r.cookies)
+				}
+				if r.body != `{"name":"koi"}` {
+					t.Errorf("body = %s, want {\"name\":\"koi\"}", r.body)
+				}
+				for _, leaked := range []string{"u1", "t1", "s1", "koi"} {
+					if strings.Contains(r.query, leaked) {
+						t.Errorf("query %q leaks %s", r.query, leaked)
+					}
+				}
+				for _, leaked := range []string{"Id", "Q", "Session", "Name"} {
+					if r.headers.Get(leaked) != "" {
+						t.Errorf("%s leaks into the headers", leaked)
+					}
+				}
+				for _, leaked := range []string{"u1", "find", "t1", "s1"} {
+					if strings.Contains(r.body, leaked) {
+						t.Errorf("body %s leaks %s", r.body, leaked)
+					}
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &shared.State{
+				Cfg:       config.Config{API: config.API{BaseURL: srv.URL}},
+				Flags:     tt.flags,
+				Variables: map[string]any{},
+			}
+			req, err := NewRequest(tt.endpoint, s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := Send(req, config.Client{}, config.TLS{}, nil); err != nil {
+				t.Fatal(err)
+			}
+			if last.uri != tt.wantURI {
+				t.Errorf("request URI = %s, want %s", last.uri, tt.wantURI)
+			}
+			if tt.check != nil {
+				tt.check(t, last)
+			}
+		})
+	}
+}
+
+func TestNewRequestMissingPathParameter(t *testing.T) {
+	e := config.Endpoint{Method: "GET", Path: "/users/{id}/posts/{post}", Parameters: map[string]config.Parameter{
+		"id": {Type: "string", In: "path"},
+	}}
+	s := &shared.State{
+		Cfg:       config.Config{API: config.API{BaseURL: "http://localhost"}},
+		Flags:     map[string]any{"id": "u1"},
+		Variables: map[string]any{},
+	}
+	if _, err := NewRequest(e, s); err == nil || !strings.Contains(err.Error(), "{post}") {
+		t.Errorf("err = %v, want no path parameter defined for {post}", err)
+	}
+}
+
+func TestBodyTemplateEscapesJSONStrings(t *testing.T) {
+	tmpl := filepath.Join(t.TempDir(), "order.json")
+	err := os.WriteFile(tmpl, []byte(`{"order": {"customer": "{{customer}}", "items": {{items}}, "token": "{{token}}"}}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	customer := "Bob \"the\" \\ builder\n<b>"
+	e := config.Endpoint{Method: "POST", Path: "/orders", BodyTemplate: tmpl, Parameters: map[string]config.Parameter{
+		"customer": {Type: "string", Required: true},
+		"items":    {Type: "array", Items: &config.Parameter{Type: "string"}},
+	}}
+	s := &shared.State{
+		Cfg:       config.Config{API: config.API{BaseURL: "http://localhost"}},
+		Flags:     map[string]any{"customer": customer, "items": []any{"a\"b"}},
+		Variables: map[string]any{"token": "t\"1"},
+	}
+
+	req, err := NewRequest(e, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(req.Body)
+	var got struct {
+		Order struct {
+			Customer string   `json:"customer"`
+			Items    []string `json:"items"`
+			Token    string   `json:"token"`
+		} `json:"order"`
+	}
+	if err := json.Unmarshal(body, &got); err != nil {
+		t.Fatalf("invalid JSON body %s: %v", body, err)
+	}
+	if got.Order.Customer != customer || got.Order.Token != "t\"1" || len(got.Order.Items) != 1 || got.Order.Items[0] != "a\"b" {
+		t.Errorf("body = %s", body)
+	}
+}
+
+func TestResolveParamsUnsetEnv(t *testing.T) {
+	os.Unsetenv("KOI_UNSET_NICK")
+	e := config.Endpoint{Method: "POST", Path: "/users", Parameters: map[string]config.Parameter{
+		"name": {Type: "string", In: "body"},
+		"nick": {Type: "string", In: "body", Mode: "env:KOI_UNSET_NICK"},
+	}}
+
+	for _, validate := range []bool{true, false} {
+		params, err := resolveParams(e, map[string]any{"name": "a"}, validate)
+		if err != nil {
+			t.Fatalf("validate %v: %v", validate, err)
+		}
+		// An unset variable without a default leaves the parameter out, it is not null
+		if len(params.body) != 1 || params.body[0].name != "name" {
+			t.Errorf("validate %v: body = %+v, want only name", validate, params.body)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package api
+
+import (
+	"cmp"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/killuox/koi/internal/config"
+	"github.com/killuox/koi/internal/interpolate"
+	"github.com/killuox/koi/internal/shared"
+)
+
+// authenticator adds the credentials of an auth type to requests
+type authenticator interface {
+	// apply adds the credentials to a request before it is sent
+	apply(req *http.Request, a config.Auth, send sender) error
+	// retry answers the challenge of a 401 response with a new request,
+	// nil when the request cannot be retried
+	retry(req *http.Request, resp *Result, a config.Auth, send sender) (*http.Request, error)
+}
+
+// sender sends the requests an authenticator needs, such as token requests,
+// with the client and TLS settings of the endpoint. It is nil when the
+// request is only shown
+type sender func(req *http.Request) (Result, error)
+
+var authenticators = map[string]authenticator{
+	"basic":     basicAuth{},
+	"bearer":    bearerAuth{},
+	"api-key":   apiKeyAuth{},
+	"digest":    digestAuth{},
+	"oauth2":    oauth2Auth{},
+	"hmac":      hmacAuth{},
+	"aws-sigv4": sigV4Auth{},
+}
+
+// resolveAuth returns the auth of the endpoint, falling back to the api one,
+// with the placeholders of its credentials resolved
+func resolveAuth(e config.Endpoint, s *shared.State) (config.Auth, error) {
+	a := s.Cfg.API.Auth
+	if e.Auth.Type != "" {
+		a = e.Auth
+	}
+	if a.Type == "none" {
+		return config.Auth{}, nil
+	}
+
+	r := interpolate.New(s.Variables)
+	for _, field := range []*string{&a.Username, &a.Password, &a.Token, &a.Key, &a.Name,
+		&a.TokenURL, &a.ClientID, &a.ClientSecret, &a.RefreshToken, &a.AuthURL, &a.RedirectURL,
+		&a.Service, &a.Region, &a.AccessKeyID, &a.SecretAccessKey, &a.SessionToken} {
+		*field = r.String(*field)
+	}
+	a.Scopes = slices.Clone(a.Scopes)
+	for i := range a.Scopes {
+		a.Scopes[i] = r.String(a.Scopes[i])
+	}
+	if err := r.Err(); err != nil {
+		return a, fmt.Errorf("%s auth: %w", a.Type, err)
+	}
+	return a, nil
+}
+
+func applyAuth(req *http.Request, a config.Auth, send sender) error {
+	if a.Type == "" {
+		return nil
+	}
+	auth, ok := authenticators[a.Type]
+	if !ok {
+		return fmt.Errorf("unsupported auth type: %s", a.Type)
+	}
+	return auth.apply(req, a, send)
+}
+
+// retryAuth returns the request answering the auth challenge of a 401
+// response, nil when there is none
+func retryAuth(req *http.Request, resp *Result, a config.Auth, send sender) (*http.Request, error) {
+	auth, ok := authenticators[a.Type]
+	if !ok || resp.Status != http.StatusUnauthorized {
+		return nil, nil
+	}
+	return auth.retry(req, resp, a, send)
+}
+
+// cloneRequest copies a request along with its body so it can be sent again
+func cloneRequest(req *http.Request) (*http.Request, error) {
+	clone := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		clone.Body = body
+	}
+	return clone, nil
+}
+
+type basicAuth struct{}
+
+func (basicAuth) apply(req *http.Request, a config.Auth, _ sender) error {
+	if a.Username == "" {
+		return fmt.Errorf("basic auth needs a username")
+	}
+	req.SetBasicAuth(a.Username, a.Password)
+	return nil
+}
+
+func (basicAuth) retry(*http.Request, *Result, config.Auth, sender) (*http.Request, error) {
+	return nil, nil
+}
+
+type bearerAuth struct{}
+
+func (bearerAuth) apply(req *http.Request, a config.Auth, _ sender) error {
+	if a.Token == "" {
+		return fmt.Errorf("bearer auth needs a token")
+	}
+	req.Header.Set("Authorization", "Bearer "+a.Token)
+	return nil
+}
+
+func (bearerAuth) retry(*http.Request, *Result, config.Auth, sender) (*http.Request, error) {
+	return nil, nil
+}
+
+type apiKeyAuth struct{}
+
+func (apiKeyAuth) apply(req *http.Request, a config.Auth, _ sender) error {
+	if a.Key == "" {
+		return fmt.Errorf("api-key auth needs a key")
+	}
+	if a.In == "query" {
+		query := req.URL.Query()
+		query.Set(cmp.Or(a.Name, "api_key"), a.Key)
+		req.URL.RawQuery = query.Encode()
+		return nil
+	}
+	req.Header.Set(cmp.Or(a.Name, "X-API-Key"), a.Key)
+	return nil
+}
+
+func (apiKeyAuth) retry(*http.Request, *Result, config.Auth, sender) (*http.Request, error) {
+	return nil, nil
+}
+
+// digestAuth answers the Digest challenge of the server (RFC 7616), the
+// first request is sent without credentials
+type digestAuth struct{}
+
+func (digestAuth) apply(req *http.Request, a config.Auth, _ sender) error {
+	if a.Username == "" {
+		return fmt.Errorf("digest auth needs a username")
+	}
+	return nil
+}
+
+func (digestAuth) retry(req *http.Request, resp *Result, a config.Auth, _ sender) (*http.Request, error) {
+	var challenge map[string]string
+	for _, header := range resp.Headers.Values("WWW-Authenticate") {
+		if scheme, params, ok := strings.Cut(header, " "); ok && strings.EqualFold(scheme, "Digest") {
+			challenge = parseAuthParams(params)
+			break
+		}
+	}
+	if challenge == nil {
+		return nil, nil
+	}
+
+	algorithm := cmp.Or(challenge["algorithm"], "MD5")
+	var newHash func() hash.Hash
+	switch strings.TrimSuffix(strings.ToUpper(algorithm), "-SESS") {
+	case "MD5":
+		newHash = md5.New
+	case "SHA-256":
+		newHash = sha256.New
+	default:
+		return nil, fmt.Errorf("unsupported digest algorithm: %s", algorithm)
+	}
+	h := func(parts ...string) string {
+		sum := newHash()
+		sum.Write([]byte(strings.Join(parts, ":")))
+		return hex.EncodeToString(sum.Sum(nil))
+	}
+
+	realm, nonce := challenge["realm"], challenge["nonce"]
+	uri := req.URL.RequestURI()
+	cnonce, err := randomHex(8)
+	if err != nil {
+		return nil, err
+	}
+	const nc = "00000001"
+
+	ha1 := h(a.Username, realm, a.Password)
+	if strings.HasSuffix(strings.ToUpper(algorithm), "-SESS") {
+		ha1 = h(ha1, nonce, cnonce)
+	}
+	ha2 := h(req.Method, uri)
+
+	header := fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s", algorithm=%s`,
+		escapeQuotes(a.Username), escapeQuotes(realm), escapeQuotes(nonce), escapeQuotes(uri), algorithm)
+	qops := strings.Split(challenge["qop"], ",")
+	for i := range qops {
+		qops[i] = strings.TrimSpace(qops[i])
+	}
+	if slices.Contains(qops, "auth") {
+		header += fmt.Sprintf(`, qop=auth, nc=%s, cnonce="%s", response="%s"`, nc, cnonce, h(ha1, nonce, nc, cnonce, "auth", ha2))
+	} else if challenge["qop"] == "" {
+		header += fmt.Sprintf(`, response="%s"`, h(ha1, nonce, ha2))
+	} else {
+		return nil, fmt.Errorf("unsupported digest qop: %s", challenge["qop"])
+	}
+	if opaque, ok := challenge["opaque"]; ok {
+		header += fmt.Sprintf(`, opaque="%s"`, escapeQuotes(opaque))
+	}
+
+	retry, err := cloneRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	retry.Header.Set("Authorization", header)
+	return retry, nil
+}
+
+// parseAuthParams parses the comma separated key=value and key="value"
+// parameters of a WWW-Authenticate challenge
+func parseAuthParams(s string) map[string]string {
+	params := map[string]string{}
+	for s = strings.TrimSpace(s); s != ""; {
+		key, rest, ok := strings.Cut(s, "=")
+		if !ok {
+			break
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		rest = strings.TrimLeft(rest, " ")
+
+		var val string
+		if strings.HasPrefix(rest, `"`) {
+			var sb strings.Builder
+			i := 1
+			for ; i < len(rest) && rest[i] != '"'; i++ {
+				if rest[i] == '\\' && i+1 < len(rest) {
+					i++
+				}
+				sb.WriteByte(rest[i])
+			}
+			val = sb.String()
+			_, rest, _ = strings.Cut(rest[min(i+1, len(rest)):], ",")
+		} else {
+			val, rest, _ = strings.Cut(rest, ",")
+		}
+		params[key] = strings.TrimSpace(val)
+		s = strings.TrimSpace(rest)
+	}
+	return params
+}
+
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("error generating random bytes: %w", err)
+	}
+	return b, nil
+}
+
+func randomHex(n int) (string, error) {
+	b, err := randomBytes(n)
+	return hex.EncodeToString(b), err
+}
@@ -0,0 +1,407 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/killuox/koi/internal/config"
+	"github.com/killuox/koi/internal/interpolate"
+	"github.com/killuox/koi/internal/shared"
+)
+
+// bodyContentTypes are the content types of a body given as is, a multipart
+// one has none as its boundary is not known
+var bodyContentTypes = map[string]string{
+	"":     "application/json",
+	"json": "application/json",
+	"form": "application/x-www-form-urlencoded",
+	"raw":  "text/plain",
+	"xml":  "application/xml",
+}
+
+// requestBody returns the body of the request: the --data body, merged over
+// the body parameters with --merge-data, the rendered body-template or the
+// encoded body parameters
+func requestBody(e config.Endpoint, s *shared.State, params []paramValue) ([]byte, string, error) {
+	switch {
+	case s.Data != nil && s.MergeData:
+		return mergeData(e, s.Data, params)
+	case s.Data != nil:
+		return s.Data, bodyContentTypes[e.BodyType], nil
+	case e.BodyTemplate != "":
+		return renderBodyTemplate(e, s, params)
+	default:
+		return encodeBody(e, params)
+	}
+}
+
+// mergeData deep merges a JSON body over the JSON encoded body parameters
+func mergeData(e config.Endpoint, data []byte, params []paramValue) ([]byte, string, error) {
+	if e.BodyType != "" && e.BodyType != "json" {
+		return nil, "", fmt.Errorf("--merge-data needs a json body-type, got %s", e.BodyType)
+	}
+
+	var override any
+	if err := json.Unmarshal(data, &override); err != nil {
+		return nil, "", fmt.Errorf("invalid --data JSON: %w", err)
+	}
+
+	base := map[string]any{}
+	for _, p := range params {
+		base[p.name] = p.value
+	}
+
+	merged, err := json.Marshal(config.MergeValue(base, override))
+	if err != nil {
+		return nil, "", fmt.Errorf("error encoding JSON: %w", err)
+	}
+	return merged, "application/json", nil
+}
+
+// renderBodyTemplate renders the body-template file with the variables and
+// the body parameters, objects and lists being rendered as JSON. In JSON
+// bodies strings are escaped, their placeholders go inside quotes
+func renderBodyTemplate(e config.Endpoint, s *shared.State, params []paramValue) ([]byte, string, error) {
+	contentType, ok := bodyContentTypes[e.BodyType]
+	if !ok {
+		return nil, "", fmt.Errorf("body-template does not support %s bodies", e.BodyType)
+	}
+
+	path := e.BodyTemplate
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(s.Cfg.Dir(), path)
+	}
+	tmpl, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("error reading body template: %w", err)
+	}
+
+	isJSON := contentType == "application/json"
+	vars := make(map[string]any, len(s.Variables)+len(params))
+	for k, v := range s.Variables {
+		if isJSON {
+			v = escapeJSONStrings(v)
+		}
+		vars[k] = v
+	}
+	for _, p := range params {
+		text, err := formText(p.value)
+		if err != nil {
+			return nil, "", err
+		}
+		if _, isString := p.value.(string); isString && isJSON {
+			text = escapeJSON(text)
+		}
+		vars[p.name] = text
+	}
+
+	r := interpolate.New(vars)
+	body := r.String(string(tmpl))
+	if err := r.Err(); err != nil {
+		return nil, "", fmt.Errorf("%s: %w", e.BodyTemplate, err)
+	}
+	return []byte(body), contentType, nil
+}
+
+// encodeBody encodes the body parameters as the endpoint body-type, json by
+// default, and returns the body along with its content type
+func encodeBody(e config.Endpoint, params []paramValue) ([]byte, string, error) {
+	bodyType := e.BodyType
+	if bodyType == "" {
+		bodyType = "json"
+	}
+
+	if bodyType == "json" || bodyType == "form" {
+		for _, p := range params {
+			if p.param.Type == "file" {
+				return nil, "", fmt.Errorf("file parameter %s needs a multipart, raw or xml body-type", p.name)
+			}
+		}
+	}
+
+	switch bodyType {
+	case "form":
+		form := url.Values{}
+		for _, p := range params {
+			if p.value != nil {
+				addForm(form, p.name, p.value, p.param.Style)
+			}
+		}
+		return []byte(form.Encode()), "application/x-www-form-urlencoded", nil
+	case "multipart":
+		return encodeMultipart(params)
+	case "raw":
+		return encodeRaw(params, "text/plain")
+	case "xml":
+		return encodeXML(params)
+	default:
+		payload := map[string]any{}
+		for _, p := range params {
+			payload[p.name] = p.value
+		}
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return nil, "", fmt.Errorf("error encoding JSON: %w", err)
+		}
+		return data, "application/json", nil
+	}
+}
+
+// addForm adds a form value, objects are sent as address[city]=Paris and
+// lists like query parameters
+func addForm(form url.Values, key string, val any, style string) {
+	obj, isObj := val.(map[string]any)
+	if !isObj {
+		addQuery(form, key, val, style)
+		return
+	}
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if obj[k] != nil {
+			addForm(form, key+"["+k+"]", obj[k], style)
+		}
+	}
+}
+
+func encodeMultipart(params []paramValue) ([]byte, string, error) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+
+	for _, p := range params {
+		if p.value == nil {
+			continue
+		}
+		if p.param.Type == "file" {
+			if err := writeFilePart(w, p.name, fmt.Sprintf("%v", p.value)); err != nil {
+				return nil, "", err
+			}
+			continue
+		}
+
+		values := []any{p.value}
+		if list, isList := p.value.([]any); isList {
+			values = list
+		}
+		for _, v := range values {
+			text, err := formText(v)
+			if err != nil {
+				return nil, "", err
+			}
+			if err := w.WriteField(p.name, text); err != nil {
+				return nil, "", err
+			}
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), w.FormDataContentType(), nil
+}
+
+// uploadsKey carries the paths of the file parameters of a request, by name
+type uploadsKey struct{}
+
+// withUploads records the paths of the file parameters in params
+func withUploads(req *http.Request, params []paramValue) *http.Request {
+	paths := map[string]string{}
+	for _, p := range params {
+		if p.param.Type == "file" && p.value != nil {
+			paths[p.name] = fmt.Sprintf("%v", p.value)
+		}
+	}
+	if len(paths) == 0 {
+		return req
+	}
+	return req.WithContext(context.WithValue(req.Context(), uploadsKey{}, paths))
+}
+
+// UploadPath returns the path of the file sent as the field name, the
+// multipart body only has its base name
+func UploadPath(req *http.Request, name string) (string, bool) {
+	path, ok := req.Context().Value(uploadsKey{}).(map[string]string)[name]
+	return path, ok
+}
+
+// writeFilePart adds a file with the content type detected from its content
+func writeFilePart(w *multipart.Writer, name, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading file for parameter %s: %w", name, err)
+	}
+
+	h := textproto.MIMEHeader{}
+	h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, escapeQuotes(name), escapeQuotes(filepath.Base(path))))
+	h.Set("Content-Type", mimetype.Detect(data).String())
+	part, err := w.CreatePart(h)
+	if err != nil {
+		return err
+	}
+	_, err = part.Write(data)
+	return err
+}
+
+// formText is the text of a form field, objects and nulls being sent as JSON
+func formText(v any) (string, error) {
+	switch v.(type) {
+	case map[string]any, []any, nil:
+		data, err := json.Marshal(v)
+		return string(data), err
+	default:
+		return fmt.Sprintf("%v", v), nil
+	}
+}
+
+// escapeJSON escapes a string to be inserted between the quotes of a JSON string
+func escapeJSON(s string) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	quoted := strings.TrimSuffix(buf.String(), "\n")
+	return quoted[1 : len(quoted)-1]
+}
+
+// escapeJSONStrings escapes the strings of a variable, including the nested ones
+func escapeJSONStrings(v any) any {
+	switch val := v.(type) {
+	case string:
+		return escapeJSON(val)
+	case map[string]any:
+		escaped := make(map[string]any, len(val))
+		for k, item := range val {
+			escaped[k] = escapeJSONStrings(item)
+		}
+		return escaped
+	case []any:
+		escaped := make([]any, len(val))
+		for i, item := range val {
+			escaped[i] = escapeJSONStrings(item)
+		}
+		return escaped
+	}
+	return v
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func escapeQuotes(s string) string {
+	return quoteEscaper.Replace(s)
+}
+
+// encodeRaw sends the value of the only body parameter as is, the content of
+// the file for a file parameter
+func encodeRaw(params []paramValue, contentType string) ([]byte, string, error) {
+	var set []paramValue
+	for _, p := range params {
+		if p.value != nil {
+			set = append(set, p)
+		}
+	}
+	if len(set) == 0 {
+		return nil, contentType, nil
+	}
+	if len(set) > 1 {
+		return nil, "", fmt.Errorf("a raw body is sent from a single body parameter, got %d", len(set))
+	}
+
+	p := set[0]
+	if p.param.Type == "file" {
+		data, err := os.ReadFile(fmt.Sprintf("%v", p.value))
+		if err != nil {
+			return nil, "", fmt.Errorf("error reading file for parameter %s: %w", p.name, err)
+		}
+		if contentType == "text/plain" {
+			contentType = mimetype.Detect(data).String()
+		}
+		return data, contentType, nil
+	}
+	text, err := formText(p.value)
+	return []byte(text), contentType, err
+}
+
+// encodeXML sends the only body parameter as the root element, objects
+// becoming child elements and lists repeated elements. A string or file
+// parameter is sent as is
+func encodeXML(params []paramValue) ([]byte, string, error) {
+	const contentType = "application/xml"
+	for _, p := range params {
+		if p.value == nil {
+			continue
+		}
+		if _, isObj := p.value.(map[string]any); !isObj {
+			return encodeRaw(params, contentType)
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", "  ")
+
+	count := 0
+	for _, p := range params {
+		if p.value == nil {
+			continue
+		}
+		if count++; count > 1 {
+			return nil, "", fmt.Errorf("an xml body is sent from a single body parameter, its root element")
+		}
+		if err := encodeXMLElement(enc, p.name, p.value); err != nil {
+			return nil, "", fmt.Errorf("error encoding XML: %w", err)
+		}
+	}
+	if err := enc.Flush(); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), contentType, nil
+}
+
+func encodeXMLElement(enc *xml.Encoder, name string, val any) error {
+	switch v := val.(type) {
+	case []any:
+		for _, item := range v {
+			if err := encodeXMLElement(enc, name, item); err != nil {
+				return err
+			}
+		}
+		return nil
+	case map[string]any:
+		start := xml.StartElement{Name: xml.Name{Local: name}}
+		if err := enc.EncodeToken(start); err != nil {
+			return err
+		}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if v[k] == nil {
+				continue
+			}
+			if err := encodeXMLElement(enc, k, v[k]); err != nil {
+				return err
+			}
+		}
+		return enc.EncodeToken(start.End())
+	default:
+		return enc.EncodeElement(fmt.Sprintf("%v", v), xml.StartElement{Name: xml.Name{Local: name}})
+	}
+}
@@ -0,0 +1,267 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/killuox/koi/internal/config"
+	"github.com/killuox/koi/internal/interpolate"
+	"github.com/killuox/koi/internal/jsonpath"
+)
+
+// transform changes a captured value before it is stored, ok is false when
+// there is nothing left to store, e.g. a regex that does not match
+type transform func(val any, args []string) (res any, ok bool, err error)
+
+var transforms = map[string]transform{
+	"trim": func(val any, _ []string) (any, bool, error) {
+		return strings.TrimSpace(captureString(val)), true, nil
+	},
+	"lower": func(val any, _ []string) (any, bool, error) {
+		return strings.ToLower(captureString(val)), true, nil
+	},
+	"upper": func(val any, _ []string) (any, bool, error) {
+		return strings.ToUpper(captureString(val)), true, nil
+	},
+	// regex 'pattern' keeps the first group, or the whole match without groups
+	"regex": func(val any, args []string) (any, bool, error) {
+		if len(args) != 1 {
+			return nil, false, fmt.Errorf("regex expects a pattern")
+		}
+		re, err := regexp.Compile(args[0])
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid regex: %w", err)
+		}
+		m := re.FindStringSubmatch(captureString(val))
+		if m == nil {
+			return nil, false, nil
+		}
+		return m[min(1, len(m)-1)], true, nil
+	},
+	"base64decode": func(val any, _ []string) (any, bool, error) {
+		decoded, err := decodeBase64(captureString(val))
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid base64 value: %w", err)
+		}
+		return string(decoded), true, nil
+	},
+	// json parses a string, e.g. a decoded base64 document
+	"json": func(val any, _ []string) (any, bool, error) {
+		var parsed any
+		if err := json.Unmarshal([]byte(captureString(val)), &parsed); err != nil {
+			return nil, false, fmt.Errorf("invalid JSON value: %w", err)
+		}
+		return parsed, true, nil
+	},
+	// jwt 'claim' reads a claim of a JWT without verifying it, all the claims
+	// without a claim
+	"jwt": func(val any, args []string) (any, bool, error) {
+		token := strings.TrimSpace(captureString(val))
+		token = strings.TrimSpace(strings.TrimPrefix(token, "Bearer "))
+		parts := strings.Split(token, ".")
+		if len(parts) != 3 {
+			return nil, false, fmt.Errorf("invalid JWT: expected 3 parts, got %d", len(parts))
+		}
+		payload, err := decodeBase64(parts[1])
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid JWT payload: %w", err)
+		}
+		var claims any
+		if err := json.Unmarshal(payload, &claims); err != nil {
+			return nil, false, fmt.Errorf("invalid JWT payload: %w", err)
+		}
+		if len(args) == 0 {
+			return claims, true, nil
+		}
+		return jsonpath.Get(claims, args[0])
+	},
+	// path 'expr' reads a JSONPath in a value, e.g. after json or jwt
+	"path": func(val any, args []string) (any, bool, error) {
+		if len(args) != 1 {
+			return nil, false, fmt.Errorf("path expects a JSONPath")
+		}
+		return jsonpath.Get(val, args[0])
+	},
+	"first": func(val any, _ []string) (any, bool, error) {
+		list, ok := val.([]any)
+		if !ok {
+			return val, true, nil
+		}
+		if len(list) == 0 {
+			return nil, false, nil
+		}
+		return list[0], true, nil
+	},
+	"last": func(val any, _ []string) (any, bool, error) {
+		list, ok := val.([]any)
+		if !ok {
+			return val, true, nil
+		}
+		if len(list) == 0 {
+			return nil, false, nil
+		}
+		return list[len(list)-1], true, nil
+	},
+}
+
+// captureVariables returns the variables set-variables captures from a
+// response. Sources that are missing from the response are left out
+func captureVariables(sv config.SetVariableConfig, result Result) (map[string]any, error) {
+	vars := map[string]any{}
+	if sv.Status != "" {
+		vars[sv.Status] = result.Status
+	}
+
+	capture := func(section string, values map[string]string, lookup func(source string) (any, bool, error)) error {
+		for varName, expr := range values {
+			source, stages, err := parseCapture(expr)
+			if err != nil {
+				return fmt.Errorf("set-variables %s.%s: %w", section, varName, err)
+			}
+			val, ok, err := lookup(source)
+			for _, stage := range stages {
+				if err != nil || !ok {
+					break
+				}
+				val, ok, err = transforms[stage[0]](val, stage[1:])
+			}
+			if err != nil {
+				return fmt.Errorf("set-variables %s.%s: %w", section, varName, err)
+			}
+			if ok {
+				vars[varName] = val
+			}
+		}
+		return nil
+	}
+
+	err := capture("headers", sv.Headers, func(name string) (any, bool, error) {
+		values := result.Headers.Values(name)
+		if len(values) == 0 {
+			return nil, false, nil
+		}
+		return values[0], true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = capture("cookies", sv.Cookies, func(name string) (any, bool, error) {
+		// The last cookie set wins, as it would in the jar
+		var cookie *http.Cookie
+		for _, c := range result.Cookies {
+			if c.Name == name {
+				cookie = c
+			}
+		}
+		if cookie == nil {
+			return nil, false, nil
+		}
+		return cookie.Value, true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sv.Body) > 0 {
+		var body any
+		if err := json.Unmarshal(result.Body, &body); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+		}
+		err = capture("body", sv.Body, func(path string) (any, bool, error) {
+			return jsonpath.Get(body, path)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return vars, nil
+}
+
+// parseCapture splits a capture into its source and transform stages, e.g.
+// `Location | regex '/users/(\d+)'`. The source ends at the first | outside
+// of quotes and brackets so JSONPath filters can use ||
+func parseCapture(expr string) (string, [][]string, error) {
+	depth, quote := 0, byte(0)
+	end := len(expr)
+loop:
+	for i := 0; i < len(expr); i++ {
+		switch c := expr[i]; {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '[' || c == '(':
+			depth++
+		case c == ']' || c == ')':
+			depth--
+		case c == '|' && depth == 0:
+			end = i
+			break loop
+		}
+	}
+
+	source := strings.TrimSpace(expr[:end])
+	if source == "" {
+		return "", nil, fmt.Errorf("missing source in %q", expr)
+	}
+	if end == len(expr) {
+		return source, nil, nil
+	}
+	// Transforms are quoted like template functions
+	tokens, err := interpolate.Tokenize(expr[end+1:])
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid transforms in %q: %w", expr, err)
+	}
+	stages := make([][]string, len(tokens))
+	for i, stage := range tokens {
+		for _, t := range stage {
+			stages[i] = append(stages[i], t.Text)
+		}
+	}
+	for _, stage := range stages {
+		if len(stage) == 0 {
+			return "", nil, fmt.Errorf("empty transform in %q", expr)
+		}
+		if _, ok := transforms[stage[0]]; !ok {
+			return "", nil, fmt.Errorf("unknown transform %q", stage[0])
+		}
+	}
+	return source, stages, nil
+}
+
+// decodeBase64 accepts standard and URL safe base64, padded or not
+func decodeBase64(s string) ([]byte, error) {
+	s = strings.TrimRight(strings.TrimSpace(s), "=")
+	if strings.ContainsAny(s, "-_") {
+		return base64.RawURLEncoding.DecodeString(s)
+	}
+	return base64.RawStdEncoding.DecodeString(s)
+}
+
+// captureString is the text of a captured value, JSON for objects and lists
+func captureString(val any) string {
+	switch v := val.(type) {
+	case string:
+		return v
+	case nil:
+		return ""
+	case float64:
+		// JSON numbers, without the exponent %v gives large ones
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case map[string]any, []any:
+		data, _ := json.Marshal(v)
+		return string(data)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
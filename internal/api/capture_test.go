@@ -0,0 +1,48 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCapture(t *testing.T) {
+	tests := []struct {
+		name   string
+		expr   string
+		source string
+		stages [][]string
+	}{
+		{name: "source only", expr: "data.id", source: "data.id"},
+		{name: "filter with ||", expr: "$.users[?(@.a || @.b)].id", source: "$.users[?(@.a || @.b)].id"},
+		{
+			name: "single quotes are raw", expr: `Location | regex '/users/(\d+)'`,
+			source: "Location", stages: [][]string{{"regex", `/users/(\d+)`}},
+		},
+		{
+			name: "double quotes are unquoted", expr: `token | regex "a\"b" | trim`,
+			source: "token", stages: [][]string{{"regex", `a"b`}, {"trim"}},
+		},
+		{
+			name: "| inside quotes", expr: `body | regex 'a|b' | upper`,
+			source: "body", stages: [][]string{{"regex", "a|b"}, {"upper"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source, stages, err := parseCapture(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if source != tt.source || !reflect.DeepEqual(stages, tt.stages) {
+				t.Errorf("parseCapture(%s) = %q %q, want %q %q", tt.expr, source, stages, tt.source, tt.stages)
+			}
+		})
+	}
+
+	for _, expr := range []string{"| trim", "id | nope", "id | trim |", "id | regex 'a"} {
+		if _, _, err := parseCapture(expr); err == nil {
+			t.Errorf("parseCapture(%s) succeeded, want an error", expr)
+		}
+	}
+}
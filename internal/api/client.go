@@ -0,0 +1,225 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/killuox/koi/internal/config"
+	"golang.org/x/crypto/pkcs12"
+	"golang.org/x/net/http/httpproxy"
+)
+
+const (
+	defaultConnectTimeout = 10 * time.Second
+	defaultTimeout        = 30 * time.Second
+	// Redirects followed by default, the same limit as net/http
+	defaultMaxRedirects = 10
+)
+
+// Redirect is a response that redirected the request
+type Redirect struct {
+	Status int
+	Url    string
+	// Cookies set by the response, e.g. the session of a login
+	Cookies []*http.Cookie
+}
+
+// unixSocketKey carries the socket of http+unix:// requests to the dialer
+type unixSocketKey struct{}
+
+// withUnixSocket sends the request through the unix socket at path
+func withUnixSocket(req *http.Request, path string) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), unixSocketKey{}, path))
+}
+
+// UnixSocket returns the unix socket a request is sent through, if any
+func UnixSocket(req *http.Request) (string, bool) {
+	path, ok := req.Context().Value(unixSocketKey{}).(string)
+	return path, ok
+}
+
+// newClient builds the HTTP client for the settings, recording every
+// redirect it follows in redirects
+func newClient(c config.Client, t config.TLS, redirects *[]Redirect) (*http.Client, error) {
+	maxRedirects := defaultMaxRedirects
+	switch c.Redirects {
+	case "", "follow":
+	case "none":
+		maxRedirects = 0
+	default:
+		n, err := strconv.Atoi(c.Redirects)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid redirects %q, expected follow, none or a number", c.Redirects)
+		}
+		maxRedirects = n
+	}
+
+	resolve, err := parseResolve(c.Resolve)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := newTLSConfig(t)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{Timeout: orDefault(c.ConnectTimeout, defaultConnectTimeout), KeepAlive: 30 * time.Second}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = c.ReadTimeout
+	transport.TLSClientConfig = tlsConfig
+	transport.Proxy = proxyFunc(c)
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if path, ok := ctx.Value(unixSocketKey{}).(string); ok {
+			return dialer.DialContext(ctx, "unix", path)
+		}
+		if override, ok := resolve[addr]; ok {
+			addr = override
+		}
+		return dialer.DialContext(ctx, network, addr)
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   orDefault(c.Timeout, defaultTimeout),
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if maxRedirects == 0 {
+				return http.ErrUseLastResponse
+			}
+			*redirects = append(*redirects, Redirect{
+				Status:  req.Response.StatusCode,
+				Url:     via[len(via)-1].URL.String(),
+				Cookies: req.Response.Cookies(),
+			})
+			if len(via) > maxRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxRedirects)
+			}
+			return nil
+		},
+	}, nil
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// newTLSConfig loads the CA bundle and client certificate of the settings
+func newTLSConfig(t config.TLS) (*tls.Config, error) {
+	cfg := &tls.Config{
+		ServerName:         t.ServerName,
+		MinVersion:         tlsVersions[t.MinVersion],
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+
+	if t.CA != "" {
+		data, err := os.ReadFile(t.CA)
+		if err != nil {
+			return nil, fmt.Errorf("error reading CA bundle: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no PEM certificates found in %s", t.CA)
+		}
+		cfg.RootCAs = pool
+	}
+
+	if t.Cert != "" {
+		cert, err := loadClientCert(t)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// loadClientCert loads a PKCS#12 file, or a PEM certificate along with its
+// key, which can also be in the certificate file
+func loadClientCert(t config.TLS) (tls.Certificate, error) {
+	ext := strings.ToLower(filepath.Ext(t.Cert))
+	if ext != ".p12" && ext != ".pfx" {
+		key := t.Key
+		if key == "" {
+			key = t.Cert
+		}
+		cert, err := tls.LoadX509KeyPair(t.Cert, key)
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("error loading client certificate: %w", err)
+		}
+		return cert, nil
+	}
+
+	data, err := os.ReadFile(t.Cert)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("error reading client certificate: %w", err)
+	}
+	blocks, err := pkcs12.ToPEM(data, t.Password)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("error decoding %s: %w", t.Cert, err)
+	}
+	var pemData []byte
+	for _, b := range blocks {
+		pemData = append(pemData, pem.EncodeToMemory(b)...)
+	}
+	cert, err := tls.X509KeyPair(pemData, pemData)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("error loading client certificate: %w", err)
+	}
+	return cert, nil
+}
+
+// proxyFunc uses the configured proxy, or the HTTP_PROXY, HTTPS_PROXY and
+// NO_PROXY env variables
+func proxyFunc(c config.Client) func(*http.Request) (*url.URL, error) {
+	cfg := httpproxy.FromEnvironment()
+	if c.Proxy != "" {
+		cfg.HTTPProxy, cfg.HTTPSProxy = c.Proxy, c.Proxy
+	}
+	if c.NoProxy != "" {
+		cfg.NoProxy = c.NoProxy
+	}
+	proxy := cfg.ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return proxy(req.URL)
+	}
+}
+
+// parseResolve maps the host:port of every host:port:ip entry to ip:port
+func parseResolve(entries []string) (map[string]string, error) {
+	resolve := map[string]string{}
+	for _, entry := range entries {
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+			return nil, fmt.Errorf("invalid resolve %q, expected host:port:ip", entry)
+		}
+		ip := strings.Trim(parts[2], "[]")
+		if net.ParseIP(ip) == nil {
+			return nil, fmt.Errorf("invalid resolve %q: %s is not an IP address", entry, ip)
+		}
+		resolve[net.JoinHostPort(parts[0], parts[1])] = net.JoinHostPort(ip, parts[1])
+	}
+	return resolve, nil
+}
+
+func orDefault(d, fallback time.Duration) time.Duration {
+	if d == 0 {
+		return fallback
+	}
+	return d
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/killuox/koi/internal/config"
+)
+
+// Client certificates in testdata are issued by testdata/ca.pem, client.p12
+// holds the same certificate and key with the password koi
+
+// serverCA writes the certificate of a TLS test server as a CA bundle
+func serverCA(t *testing.T, srv *httptest.Server) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "server-ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func getTLS(t *testing.T, srv *httptest.Server, tc config.TLS) (Result, error) {
+	t.Helper()
+	req, err := http.NewRequest("GET", srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Send(req, config.Client{}, tc, nil)
+}
+
+func TestTLSCustomCA(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer srv.Close()
+
+	if _, err := getTLS(t, srv, config.TLS{}); err == nil {
+		t.Fatal("request succeeded without trusting the server certificate")
+	}
+	result, err := getTLS(t, srv, config.TLS{CA: serverCA(t, srv)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.TLS == nil || len(result.TLS.PeerCertificates) == 0 {
+		t.Error("no TLS details in the result")
+	}
+}
+
+func TestTLSClientCertificate(t *testing.T) {
+	caPEM, err := os.ReadFile("testdata/ca.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AppendCertsFromPEM(caPEM)
+
+	var subject string
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		subject = r.TLS.PeerCertificates[0].Subject.CommonName
+	}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	srv.StartTLS()
+	defer srv.Close()
+	ca := serverCA(t, srv)
+
+	tests := []struct {
+		name string
+		tls  config.TLS
+	}{
+		{name: "pem", tls: config.TLS{CA: ca, Cert: "testdata/client.pem", Key: "testdata/client-key.pem"}},
+		{name: "pkcs12", tls: config.TLS{CA: ca, Cert: "testdata/client.p12", Password: "koi"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subject = ""
+			if _, err := getTLS(t, srv, tt.tls); err != nil {
+				t.Fatal(err)
+			}
+			if subject != "koi client" {
+				t.Errorf("client certificate subject = %q, want koi client", subject)
+			}
+		})
+	}
+
+	t.Run("no certificate", func(t *testing.T) {
+		if _, err := getTLS(t, srv, config.TLS{CA: ca}); err == nil {
+			t.Error("request succeeded without a client certificate")
+		}
+	})
+	t.Run("wrong pkcs12 password", func(t *testing.T) {
+		_, err := getTLS(t, srv, config.TLS{CA: ca, Cert: "testdata/client.p12", Password: "nope"})
+		if err == nil || !strings.Contains(err.Error(), "client.p12") {
+			t.Errorf("err = %v, want an error decoding client.p12", err)
+		}
+	})
+}
+
+func TestTLSMinVersion(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	srv.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	srv.StartTLS()
+	defer srv.Close()
+	ca := serverCA(t, srv)
+
+	result, err := getTLS(t, srv, config.TLS{CA: ca, MinVersion: "1.2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.TLS.Version != tls.VersionTLS12 {
+		t.Errorf("version = %x, want TLS 1.2", result.TLS.Version)
+	}
+	if _, err := getTLS(t, srv, config.TLS{CA: ca, MinVersion: "1.3"}); err == nil {
+		t.Error("min-version 1.3 accepted a TLS 1.2 server")
+	}
+}
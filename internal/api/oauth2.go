@@ -0,0 +1,356 @@
+package api
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/killuox/koi/internal/config"
+	"github.com/killuox/koi/internal/userdata"
+)
+
+const (
+	// Tokens are renewed when they expire within this delay
+	tokenExpirySkew = 30 * time.Second
+	// Time given to the user to authorize koi in the browser
+	authorizeTimeout = 5 * time.Minute
+)
+
+// oauth2Token is a token cached in the token store
+type oauth2Token struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+func (t oauth2Token) valid() bool {
+	return t.AccessToken != "" && (t.ExpiresAt.IsZero() || time.Until(t.ExpiresAt) > tokenExpirySkew)
+}
+
+// oauth2Auth sends a bearer token obtained from the token URL, cached until
+// it expires and renewed with its refresh token when there is one
+type oauth2Auth struct{}
+
+func (o oauth2Auth) apply(req *http.Request, a config.Auth, send sender) error {
+	if a.TokenURL == "" {
+		return fmt.Errorf("oauth2 auth needs a token-url")
+	}
+	token := cachedToken(a)
+	// A request that is only shown does not fetch a token
+	if !token.valid() && send == nil {
+		req.Header.Set("Authorization", "Bearer <oauth2 token>")
+		return nil
+	}
+	if !token.valid() {
+		var err error
+		if token, err = o.renew(a, token, send); err != nil {
+			return err
+		}
+	}
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	return nil
+}
+
+// retry renews a token the server rejected, it may have been revoked before it expired
+func (o oauth2Auth) retry(req *http.Request, _ *Result, a config.Auth, send sender) (*http.Request, error) {
+	token, err := o.renew(a, cachedToken(a), send)
+	if err != nil {
+		return nil, err
+	}
+	retry, err := cloneRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	retry.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	return retry, nil
+}
+
+// renew gets a new token with the refresh token of the cached one, falling
+// back to the grant of the auth, and caches it
+func (oauth2Auth) renew(a config.Auth, cached oauth2Token, send sender) (oauth2Token, error) {
+	var token oauth2Token
+	var err error
+	if cached.RefreshToken != "" {
+		token, err = requestToken(a, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {cached.RefreshToken}}, send)
+	}
+	if cached.RefreshToken == "" || err != nil {
+		if token, err = grantToken(a, send); err != nil {
+			return token, err
+		}
+	}
+
+	// Servers may keep the refresh token when refreshing
+	if token.RefreshToken == "" {
+		token.RefreshToken = cached.RefreshToken
+	}
+	if err := cacheToken(a, token); err != nil {
+		return token, fmt.Errorf("error caching oauth2 token: %w", err)
+	}
+	return token, nil
+}
+
+// grantToken requests a token with the grant of the auth
+func grantToken(a config.Auth, send sender) (oauth2Token, error) {
+	switch grant := cmp.Or(a.Grant, "client_credentials"); grant {
+	case "client_credentials":
+		return requestToken(a, url.Values{"grant_type": {grant}}, send)
+	case "password":
+		if a.Username == "" {
+			return oauth2Token{}, fmt.Errorf("oauth2 password grant needs a username")
+		}
+		return requestToken(a, url.Values{"grant_type": {grant}, "username": {a.Username}, "password": {a.Password}}, send)
+	case "refresh_token":
+		if a.RefreshToken == "" {
+			return oauth2Token{}, fmt.Errorf("oauth2 refresh_token grant needs a refresh-token")
+		}
+		return requestToken(a, url.Values{"grant_type": {grant}, "refresh_token": {a.RefreshToken}}, send)
+	case "authorization_code", "authorization_code+pkce":
+		return authorize(a, send)
+	default:
+		return oauth2Token{}, fmt.Errorf("unsupported oauth2 grant: %s", grant)
+	}
+}
+
+// requestToken posts a token request (RFC 6749) to the token URL
+func requestToken(a config.Auth, form url.Values, send sender) (oauth2Token, error) {
+	if len(a.Scopes) > 0 {
+		form.Set("scope", strings.Join(a.Scopes, " "))
+	}
+	// Public clients and client-auth: body send their id along with the form
+	basic := a.ClientSecret != "" && a.ClientAuth != "body"
+	if !basic && a.ClientID != "" {
+		form.Set("client_id", a.ClientID)
+		if a.ClientSecret != "" {
+			form.Set("client_secret", a.ClientSecret)
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodPost, a.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return oauth2Token{}, fmt.Errorf("invalid token-url: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if basic {
+		req.SetBasicAuth(url.QueryEscape(a.ClientID), url.QueryEscape(a.ClientSecret))
+	}
+
+	resp, err := send(req)
+	if err != nil {
+		return oauth2Token{}, fmt.Errorf("token request failed: %w", err)
+	}
+	fields, err := tokenResponse(resp)
+	if err != nil {
+		return oauth2Token{}, err
+	}
+	if resp.Status != http.StatusOK || fields["error"] != "" {
+		message := cmp.Or(strings.Trim(fields["error"]+": "+fields["error_description"], ": "), strings.TrimSpace(string(resp.Body)))
+		return oauth2Token{}, fmt.Errorf("token request failed with status %d: %s", resp.Status, message)
+	}
+	if fields["access_token"] == "" {
+		return oauth2Token{}, fmt.Errorf("token response has no access_token")
+	}
+
+	token := oauth2Token{AccessToken: fields["access_token"], RefreshToken: fields["refresh_token"]}
+	if expiresIn, err := strconv.ParseFloat(fields["expires_in"], 64); err == nil {
+		token.ExpiresAt = time.Now().Add(time.Duration(expiresIn * float64(time.Second)))
+	}
+	return token, nil
+}
+
+// tokenResponse reads the fields of a JSON or, for some older servers, form
+// encoded token response
+func tokenResponse(resp Result) (map[string]string, error) {
+	fields := map[string]string{}
+	mediaType, _, _ := mime.ParseMediaType(resp.Headers.Get("Content-Type"))
+	if mediaType == "application/x-www-form-urlencoded" || mediaType == "text/plain" {
+		values, err := url.ParseQuery(string(resp.Body))
+		if err == nil {
+			for key := range values {
+				fields[key] = values.Get(key)
+			}
+			return fields, nil
+		}
+	}
+
+	var data map[string]any
+	if err := json.Unmarshal(resp.Body, &data); err != nil {
+		if resp.Status != http.StatusOK {
+			return fields, nil
+		}
+		return nil, fmt.Errorf("invalid token response: %w", err)
+	}
+	for key, val := range data {
+		fields[key] = fmt.Sprintf("%v", val)
+	}
+	return fields, nil
+}
+
+// authorize runs the authorization code flow with PKCE (RFC 7636), the user
+// authorizes koi in the browser which redirects to a loopback listener
+func authorize(a config.Auth, send sender) (oauth2Token, error) {
+	if a.AuthURL == "" {
+		return oauth2Token{}, fmt.Errorf("oauth2 authorization_code grant needs an auth-url")
+	}
+
+	redirect, err := url.Parse(cmp.Or(a.RedirectURL, "http://127.0.0.1:0/callback"))
+	if err != nil || redirect.Scheme != "http" {
+		return oauth2Token{}, fmt.Errorf("invalid redirect-url %q, expected a http://127.0.0.1:<port>/<path> URL", a.RedirectURL)
+	}
+	listener, err := net.Listen("tcp", redirect.Host)
+	if err != nil {
+		return oauth2Token{}, fmt.Errorf("error listening for the oauth2 redirect: %w", err)
+	}
+	defer listener.Close()
+	// A free port was picked when none is configured
+	if redirect.Port() == "0" {
+		redirect.Host = listener.Addr().String()
+	}
+
+	// A predictable verifier or state would let others use the code
+	random, err := randomBytes(32)
+	if err != nil {
+		return oauth2Token{}, err
+	}
+	verifier := base64.RawURLEncoding.EncodeToString(random)
+	challenge := sha256.Sum256([]byte(verifier))
+	state, err := randomHex(16)
+	if err != nil {
+		return oauth2Token{}, err
+	}
+
+	authURL, err := url.Parse(a.AuthURL)
+	if err != nil {
+		return oauth2Token{}, fmt.Errorf("invalid auth-url: %w", err)
+	}
+	query := authURL.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", a.ClientID)
+	query.Set("redirect_uri", redirect.String())
+	query.Set("state", state)
+	query.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	query.Set("code_challenge_method", "S256")
+	if len(a.Scopes) > 0 {
+		query.Set("scope", strings.Join(a.Scopes, " "))
+	}
+	authURL.RawQuery = query.Encode()
+
+	type callback struct {
+		code string
+		err  error
+	}
+	done := make(chan callback, 1)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != redirect.Path {
+			http.NotFound(w, r)
+			return
+		}
+		q := r.URL.Query()
+		var result callback
+		switch {
+		case q.Get("error") != "":
+			result.err = fmt.Errorf("authorization failed: %s", strings.Trim(q.Get("error")+": "+q.Get("error_description"), ": "))
+		case q.Get("state") != state:
+			result.err = fmt.Errorf("authorization failed: the state does not match")
+		case q.Get("code") == "":
+			result.err = fmt.Errorf("authorization failed: no code in the redirect")
+		default:
+			result.code = q.Get("code")
+		}
+		if result.err != nil {
+			http.Error(w, result.err.Error(), http.StatusBadRequest)
+		} else {
+			fmt.Fprintln(w, "koi is authorized, you can close this window.")
+		}
+		select {
+		case done <- result:
+		default:
+		}
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	if err := openBrowser(authURL.String()); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not open a browser (%s), open this URL to authorize koi:\n%s\n", err, authURL)
+	} else {
+		fmt.Fprintf(os.Stderr, "Authorize koi in your browser, or open this URL:\n%s\n", authURL)
+	}
+
+	var result callback
+	select {
+	case result = <-done:
+	case <-time.After(authorizeTimeout):
+		return oauth2Token{}, fmt.Errorf("authorization timed out after %s", authorizeTimeout)
+	}
+	if result.err != nil {
+		return oauth2Token{}, result.err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {result.code},
+		"redirect_uri":  {redirect.String()},
+		"code_verifier": {verifier},
+	}
+	// The client id is sent even along with basic auth, the code was issued to it
+	if a.ClientID != "" {
+		form.Set("client_id", a.ClientID)
+	}
+	return requestToken(a, form, send)
+}
+
+// cachedToken returns the cached token of the auth, if any
+func cachedToken(a config.Auth) oauth2Token {
+	tokens := map[string]oauth2Token{}
+	if err := userdata.Load("tokens", &tokens); err != nil {
+		return oauth2Token{}
+	}
+	return tokens[tokenCacheKey(a)]
+}
+
+// cacheToken stores the token of the auth in ~/.koi/tokens.json
+func cacheToken(a config.Auth, token oauth2Token) error {
+	tokens := map[string]oauth2Token{}
+	if err := userdata.Load("tokens", &tokens); err != nil {
+		return err
+	}
+	tokens[tokenCacheKey(a)] = token
+	// Refresh tokens are long lived, keep them private to the user
+	return userdata.Save("tokens", tokens, 0600)
+}
+
+// tokenCacheKey identifies the tokens of an auth in the token store, the
+// same client and user share their tokens across endpoints
+func tokenCacheKey(a config.Auth) string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{
+		cmp.Or(a.Grant, "client_credentials"), a.TokenURL, a.ClientID, a.Username, strings.Join(a.Scopes, " "),
+	}, "\n")))
+	return "oauth2:" + hex.EncodeToString(sum[:8])
+}
+
+// openBrowser opens a URL in the default browser, without waiting for it
+func openBrowser(u string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", u)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", u)
+	default:
+		cmd = exec.Command("xdg-open", u)
+	}
+	return cmd.Start()
+}
@@ -0,0 +1,202 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/killuox/koi/internal/config"
+	"github.com/killuox/koi/internal/shared"
+	"github.com/killuox/koi/internal/userdata"
+)
+
+// tokenServer is a stub token endpoint recording the token requests it gets
+type tokenServer struct {
+	*httptest.Server
+	requests []*http.Request
+	forms    []url.Values
+	// Tokens handed out in order, the last one is repeated
+	tokens []map[string]any
+}
+
+func newTokenServer(t *testing.T, tokens ...map[string]any) *tokenServer {
+	t.Helper()
+	ts := &tokenServer{tokens: tokens}
+	ts.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		ts.requests = append(ts.requests, r)
+		ts.forms = append(ts.forms, r.PostForm)
+		token := ts.tokens[min(len(ts.forms), len(ts.tokens))-1]
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(token)
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// withHome keeps the cached tokens of a test in a temporary home
+func withHome(t *testing.T) {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+}
+
+func testSender(req *http.Request) (Result, error) {
+	return Send(req, config.Client{}, config.TLS{}, nil)
+}
+
+func applyOAuth2(t *testing.T, a config.Auth) string {
+	t.Helper()
+	req := httptest.NewRequest("GET", "http://api.example.com/users", nil)
+	if err := (oauth2Auth{}).apply(req, a, testSender); err != nil {
+		t.Fatal(err)
+	}
+	return req.Header.Get("Authorization")
+}
+
+func TestOAuth2ClientCredentials(t *testing.T) {
+	tests := []struct {
+		name       string
+		clientAuth string
+		basic      bool
+	}{
+		{name: "basic client auth", clientAuth: "", basic: true},
+		{name: "body client auth", clientAuth: "body", basic: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withHome(t)
+			ts := newTokenServer(t, map[string]any{"access_token": "t1", "token_type": "Bearer", "expires_in": 3600})
+			a := config.Auth{
+				Type: "oauth2", TokenURL: ts.URL, ClientID: "koi", ClientSecret: "s3cret",
+				ClientAuth: tt.clientAuth, Scopes: []string{"read", "write"},
+			}
+
+			if got := applyOAuth2(t, a); got != "Bearer t1" {
+				t.Errorf("Authorization = %q, want Bearer t1", got)
+			}
+			// The token is cached until it expires
+			if got := applyOAuth2(t, a); got != "Bearer t1" {
+				t.Errorf("cached Authorization = %q, want Bearer t1", got)
+			}
+			if len(ts.forms) != 1 {
+				t.Fatalf("token requests = %d, want 1", len(ts.forms))
+			}
+
+			form := ts.forms[0]
+			if form.Get("grant_type") != "client_credentials" || form.Get("scope") != "read write" {
+				t.Errorf("form = %v, want the client_credentials grant with the scopes", form)
+			}
+			user, pass, hasBasic := ts.requests[0].BasicAuth()
+			if tt.basic {
+				if !hasBasic || user != "koi" || pass != "s3cret" {
+					t.Errorf("basic auth = %q:%q (%v), want koi:s3cret", user, pass, hasBasic)
+				}
+				if form.Has("client_id") || form.Has("client_secret") {
+					t.Errorf("form = %v, want no client credentials with basic auth", form)
+				}
+			} else {
+				if hasBasic {
+					t.Errorf("basic auth sent with client-auth: body")
+				}
+				if form.Get("client_id") != "koi" || form.Get("client_secret") != "s3cret" {
+					t.Errorf("form = %v, want the client credentials", form)
+				}
+			}
+		})
+	}
+}
+
+func TestOAuth2RefreshBeforeExpiry(t *testing.T) {
+	withHome(t)
+	// The first token expires within the skew, so it is refreshed on the next request
+	expiresIn := (tokenExpirySkew - 5*time.Second).Seconds()
+	ts := newTokenServer(t,
+		map[string]any{"access_token": "t1", "refresh_token": "r1", "expires_in": expiresIn},
+		map[string]any{"access_token": "t2", "expires_in": 3600},
+	)
+	a := config.Auth{Type: "oauth2", TokenURL: ts.URL, ClientID: "koi", ClientSecret: "s3cret"}
+
+	if got := applyOAuth2(t, a); got != "Bearer t1" {
+		t.Fatalf("Authorization = %q, want Bearer t1", got)
+	}
+	if got := applyOAuth2(t, a); got != "Bearer t2" {
+		t.Fatalf("Authorization = %q, want Bearer t2", got)
+	}
+	if len(ts.forms) != 2 {
+		t.Fatalf("token requests = %d, want 2", len(ts.forms))
+	}
+	if ts.forms[1].Get("grant_type") != "refresh_token" || ts.forms[1].Get("refresh_token") != "r1" {
+		t.Errorf("second token request = %v, want a refresh_token grant with r1", ts.forms[1])
+	}
+	// The refresh token is kept when the server does not send a new one
+	if token := cachedToken(a); token.RefreshToken != "r1" || token.AccessToken != "t2" {
+		t.Errorf("cached token = %+v, want t2 with the r1 refresh token", token)
+	}
+	// Refresh tokens are only readable by the user
+	path, err := userdata.Path("tokens")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("token cache = %v (%v), want mode 0600", info, err)
+	}
+}
+
+func TestOAuth2RetryOn401(t *testing.T) {
+	tests := []struct {
+		name      string
+		fresh     string
+		wantCalls int
+		want      int
+	}{
+		{name: "renewed token is accepted", fresh: "valid", wantCalls: 2, want: http.StatusOK},
+		{name: "retried only once", fresh: "rejected", wantCalls: 2, want: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withHome(t)
+			ts := newTokenServer(t, map[string]any{"access_token": tt.fresh, "expires_in": 3600})
+
+			calls := 0
+			api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				if r.Header.Get("Authorization") != "Bearer valid" {
+					w.WriteHeader(http.StatusUnauthorized)
+				}
+			}))
+			defer api.Close()
+
+			a := config.Auth{Type: "oauth2", TokenURL: ts.URL, ClientID: "koi", ClientSecret: "s3cret"}
+			// A token revoked before it expired
+			revoked := oauth2Token{AccessToken: "revoked", ExpiresAt: time.Now().Add(time.Hour)}
+			if err := cacheToken(a, revoked); err != nil {
+				t.Fatal(err)
+			}
+
+			s := &shared.State{
+				Cfg:       config.Config{API: config.API{BaseURL: api.URL, Auth: a}},
+				Flags:     map[string]any{},
+				Variables: map[string]any{},
+			}
+			result, err := Call(config.Endpoint{Method: "GET", Path: "/users"}, s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Status != tt.want {
+				t.Errorf("status = %d, want %d", result.Status, tt.want)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("api calls = %d, want %d", calls, tt.wantCalls)
+			}
+			if len(ts.forms) != 1 {
+				t.Errorf("token requests = %d, want 1", len(ts.forms))
+			}
+		})
+	}
+}
@@ -0,0 +1,289 @@
+package api
+
+import (
+	"cmp"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/killuox/koi/internal/config"
+	"github.com/killuox/koi/internal/env"
+	"github.com/killuox/koi/internal/interpolate"
+)
+
+const defaultCanonical = "{{method}}\n{{path}}\n{{timestamp}}\n{{body_sha256}}"
+
+var hmacHashes = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+	"sha1":   sha1.New,
+}
+
+// hmacAuth signs a canonical string of the request with a shared secret
+type hmacAuth struct{}
+
+func (hmacAuth) apply(req *http.Request, a config.Auth, _ sender) error {
+	return signHMAC(req, a, time.Now())
+}
+
+func (hmacAuth) retry(*http.Request, *Result, config.Auth, sender) (*http.Request, error) {
+	return nil, nil
+}
+
+func signHMAC(req *http.Request, a config.Auth, t time.Time) error {
+	if a.Key == "" {
+		return fmt.Errorf("hmac auth needs a key")
+	}
+	body, err := BodyBytes(req)
+	if err != nil {
+		return err
+	}
+
+	var timestamp string
+	switch a.TimestampFormat {
+	case "unix-ms":
+		timestamp = strconv.FormatInt(t.UnixMilli(), 10)
+	case "rfc3339":
+		timestamp = t.UTC().Format(time.RFC3339)
+	default:
+		timestamp = strconv.FormatInt(t.Unix(), 10)
+	}
+	// The timestamp header is set first so the canonical string can include it
+	req.Header.Set(cmp.Or(a.TimestampHeader, "X-Timestamp"), timestamp)
+
+	headers := map[string]any{}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.Join(values, ",")
+	}
+	nonce, err := randomHex(16)
+	if err != nil {
+		return err
+	}
+	bodyHash := sha256.Sum256(body)
+	vars := map[string]any{
+		"method":      req.Method,
+		"path":        req.URL.EscapedPath(),
+		"query":       req.URL.RawQuery,
+		"host":        req.Host,
+		"timestamp":   timestamp,
+		"nonce":       nonce,
+		"body":        string(body),
+		"body_sha256": hex.EncodeToString(bodyHash[:]),
+		"header":      headers,
+	}
+
+	r := interpolate.NewScoped(vars)
+	canonical := r.String(cmp.Or(a.Canonical, defaultCanonical))
+	if err := r.Err(); err != nil {
+		return fmt.Errorf("hmac canonical: %w", err)
+	}
+
+	mac := hmac.New(hmacHashes[cmp.Or(a.Algorithm, "sha256")], []byte(a.Key))
+	mac.Write([]byte(canonical))
+	vars["signature"] = hex.EncodeToString(mac.Sum(nil))
+	if a.Encoding == "base64" {
+		vars["signature"] = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	}
+
+	signature := r.String(cmp.Or(a.Signature, "{{signature}}"))
+	if err := r.Err(); err != nil {
+		return fmt.Errorf("hmac signature: %w", err)
+	}
+	req.Header.Set(cmp.Or(a.SignatureHeader, "X-Signature"), signature)
+	return nil
+}
+
+// sigV4Auth signs requests with AWS Signature Version 4
+type sigV4Auth struct{}
+
+func (sigV4Auth) apply(req *http.Request, a config.Auth, _ sender) error {
+	return signV4(req, a, time.Now())
+}
+
+func (sigV4Auth) retry(*http.Request, *Result, config.Auth, sender) (*http.Request, error) {
+	return nil, nil
+}
+
+// Headers left out of the signature, proxies and clients may change them.
+// The cookies of the jar are added to the Cookie header after signing
+var sigV4Unsigned = map[string]bool{
+	"authorization":   true,
+	"user-agent":      true,
+	"x-amzn-trace-id": true,
+	"expect":          true,
+	"cookie":          true,
+}
+
+func signV4(req *http.Request, a config.Auth, t time.Time) error {
+	accessKey, _ := env.GetString("AWS_ACCESS_KEY_ID", "")
+	secretKey, _ := env.GetString("AWS_SECRET_ACCESS_KEY", "")
+	sessionToken, _ := env.GetString("AWS_SESSION_TOKEN", "")
+	region, _ := env.GetString("AWS_REGION", "")
+	accessKey, secretKey = cmp.Or(a.AccessKeyID, accessKey), cmp.Or(a.SecretAccessKey, secretKey)
+	sessionToken, region = cmp.Or(a.SessionToken, sessionToken), cmp.Or(a.Region, region)
+	if accessKey == "" || secretKey == "" {
+		return fmt.Errorf("aws-sigv4 auth needs an access-key-id and a secret-access-key")
+	}
+	if a.Service == "" || region == "" {
+		return fmt.Errorf("aws-sigv4 auth needs a service and a region")
+	}
+
+	body, err := BodyBytes(req)
+	if err != nil {
+		return err
+	}
+	bodyHash := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(bodyHash[:])
+
+	t = t.UTC()
+	amzDate, date := t.Format("20060102T150405Z"), t.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	if sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", sessionToken)
+	}
+	if a.Service == "s3" {
+		req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	}
+
+	// Canonical headers, sorted by their lowercase name along with the host
+	values := map[string]string{"host": cmp.Or(req.Host, req.URL.Host)}
+	for name, vals := range req.Header {
+		name = strings.ToLower(name)
+		if sigV4Unsigned[name] {
+			continue
+		}
+		trimmed := make([]string, len(vals))
+		for i, v := range vals {
+			trimmed[i] = strings.Join(strings.Fields(v), " ")
+		}
+		values[name] = strings.Join(trimmed, ",")
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + values[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		sigV4Path(req.URL, a.Service == "s3"),
+		sigV4Query(req),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/" + a.Service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + secretKey)
+	for _, part := range []string{date, region, a.Service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature))
+	return nil
+}
+
+// sigV4Path is the canonical URI of a request, built from the path as it is
+// sent. Every service but S3 normalizes it and encodes each segment a second
+// time, S3 signs object keys as they are and encodes them once
+func sigV4Path(u *url.URL, s3 bool) string {
+	p := u.EscapedPath()
+	// Opaque URLs are sent as is, without the scheme and host
+	if u.Opaque != "" {
+		p = u.Opaque
+		if strings.HasPrefix(p, "//") {
+			p = "/"
+			if i := strings.Index(u.Opaque[2:], "/"); i >= 0 {
+				p = u.Opaque[2+i:]
+			}
+		}
+	}
+	if p == "" {
+		return "/"
+	}
+	if !s3 {
+		trailingSlash := strings.HasSuffix(p, "/")
+		p = path.Clean(p)
+		if trailingSlash && p != "/" {
+			p += "/"
+		}
+	}
+	segments := strings.Split(p, "/")
+	for i, s := range segments {
+		if s3 {
+			// Escaped slashes stay in their segment, they are part of the key
+			if unescaped, err := url.PathUnescape(s); err == nil {
+				s = unescaped
+			}
+		}
+		segments[i] = sigV4Escape(s)
+	}
+	return strings.Join(segments, "/")
+}
+
+// sigV4Query sorts the query parameters by name and value
+func sigV4Query(req *http.Request) string {
+	var pairs []string
+	for name, vals := range req.URL.Query() {
+		for _, v := range vals {
+			pairs = append(pairs, sigV4Escape(name)+"="+sigV4Escape(v))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// sigV4Escape percent-encodes everything but the unreserved characters
+func sigV4Escape(s string) string {
+	var sb strings.Builder
+	for _, b := range []byte(s) {
+		if 'A' <= b && b <= 'Z' || 'a' <= b && b <= 'z' || '0' <= b && b <= '9' || strings.IndexByte("-_.~", b) >= 0 {
+			sb.WriteByte(b)
+		} else {
+			fmt.Fprintf(&sb, "%%%02X", b)
+		}
+	}
+	return sb.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// BodyBytes reads the body of a request without consuming it
+func BodyBytes(req *http.Request) ([]byte, error) {
+	if req.GetBody == nil {
+		return nil, nil
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, fmt.Errorf("error reading request body: %w", err)
+	}
+	defer body.Close()
+	return io.ReadAll(body)
+}
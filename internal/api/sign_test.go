@@ -0,0 +1,229 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/killuox/koi/internal/config"
+)
+
+// Known answers from the AWS SigV4 test suite, which signs its requests with
+// these credentials at 20150830T123600Z
+func TestSignV4(t *testing.T) {
+	a := config.Auth{
+		Type:            "aws-sigv4",
+		Service:         "service",
+		Region:          "us-east-1",
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+	}
+	signedAt := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		method  string
+		url     string
+		opaque  string
+		body    string
+		headers [][2]string
+		signed  string
+		want    string
+	}{
+		{
+			name:   "get-vanilla",
+			method: "GET",
+			url:    "/",
+			signed: "host;x-amz-date",
+			want:   "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			// The jar changes the cookies after signing, they are not signed
+			name:    "get-vanilla with cookies",
+			method:  "GET",
+			url:     "/",
+			headers: [][2]string{{"Cookie", "lang=en"}},
+			signed:  "host;x-amz-date",
+			want:    "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			name:   "get-vanilla-query-order-key",
+			method: "GET",
+			url:    "/?Param2=value2&Param1=value1",
+			signed: "host;x-amz-date",
+			want:   "b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500",
+		},
+		{
+			name:   "get-vanilla-empty-query-key",
+			method: "GET",
+			url:    "/?Param1=value1",
+			signed: "host;x-amz-date",
+			want:   "a67d582fa61cc504c4bae71f336f98b97f1ea3c7a6bfe1b6e45aec72011b9aeb",
+		},
+		{
+			// The suite sends its request line as is, as an opaque URL does
+			name:   "get-space",
+			method: "GET",
+			url:    "/",
+			opaque: "/example space/",
+			signed: "host;x-amz-date",
+			want:   "652487583200325589f1fba4c7e578f72c47cb61beeca81406b39ddec1366741",
+		},
+		{
+			name:   "get-utf8",
+			method: "GET",
+			url:    "/",
+			opaque: "/ሴ",
+			signed: "host;x-amz-date",
+			want:   "8318018e0b0f223aa2bbf98705b62bb787dc9c0e678f255a891fd03141be5d85",
+		},
+		{
+			name:   "get-slashes",
+			method: "GET",
+			url:    "//example//",
+			signed: "host;x-amz-date",
+			want:   "9a624bd73a37c9a373b5312afbebe7a714a789de108f0bdfe846570885f57e84",
+		},
+		{
+			name:   "get-relative-relative",
+			method: "GET",
+			url:    "/example1/example2/../..",
+			signed: "host;x-amz-date",
+			want:   "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			name:   "get-unreserved",
+			method: "GET",
+			url:    "/-._~0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz",
+			signed: "host;x-amz-date",
+			want:   "07ef7494c76fa4850883e2b006601f940f8a34d404d0cfa977f52a65bbf5f24f",
+		},
+		{
+			name:    "get-header-value-trim",
+			method:  "GET",
+			url:     "/",
+			headers: [][2]string{{"My-Header1", " value1"}, {"My-Header2", ` "a   b   c"`}},
+			signed:  "host;my-header1;my-header2;x-amz-date",
+			want:    "acc3ed3afb60bb290fc8d2dd0098b9911fcaa05412b367055dee359757a9c736",
+		},
+		{
+			name:   "post-vanilla",
+			method: "POST",
+			url:    "/",
+			signed: "host;x-amz-date",
+			want:   "5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b",
+		},
+		{
+			name:    "post-x-www-form-urlencoded",
+			method:  "POST",
+			url:     "/",
+			body:    "Param1=value1",
+			headers: [][2]string{{"Content-Type", "application/x-www-form-urlencoded"}},
+			signed:  "content-type;host;x-amz-date",
+			want:    "ff11897932ad3f4e8b18135d722051e5ac45fc38421b1da7b9d196a0fe09473a",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, "https://example.amazonaws.com"+tt.url, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.opaque != "" {
+				req.URL.Opaque = tt.opaque
+			}
+			for _, h := range tt.headers {
+				req.Header.Set(h[0], h[1])
+			}
+			if err := signV4(req, a, signedAt); err != nil {
+				t.Fatal(err)
+			}
+
+			want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+				"SignedHeaders=" + tt.signed + ", Signature=" + tt.want
+			if got := req.Header.Get("Authorization"); got != want {
+				t.Errorf("Authorization = %s\nwant %s", got, want)
+			}
+		})
+	}
+}
+
+func TestSigV4Path(t *testing.T) {
+	tests := []struct {
+		path string
+		s3   bool
+		want string
+	}{
+		{"/users/a%20b%2Fc", false, "/users/a%2520b%252Fc"},
+		{"/users/x%2Fy", false, "/users/x%252Fy"},
+		{"/a/./b/../c/", false, "/a/c/"},
+		{"/bucket/a%20b%2Fc", true, "/bucket/a%20b%2Fc"},
+		{"/bucket/a/../b", true, "/bucket/a/../b"},
+		{"", false, "/"},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest("GET", "https://example.amazonaws.com"+tt.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := sigV4Path(req.URL, tt.s3); got != tt.want {
+			t.Errorf("sigV4Path(%q, s3=%v) = %s, want %s", tt.path, tt.s3, got, tt.want)
+		}
+	}
+}
+
+// The HMAC vectors are computed with openssl from the canonical strings the
+// request below should give, 1440938160 being signedAt in seconds:
+//
+//	body=$(printf '%s' '{"name":"koi"}' | openssl dgst -sha256 -r | cut -d' ' -f1)
+//	printf 'POST\n/v1/orders\n1440938160\n%s' "$body" | openssl dgst -sha256 -hmac secret
+//	printf 'POST /v1/orders?page=2 1440938160000' | openssl dgst -sha512 -hmac secret -binary | openssl base64 -A
+func TestSignHMAC(t *testing.T) {
+	signedAt := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		auth   config.Auth
+		header string
+		want   string
+	}{
+		{
+			name:   "defaults",
+			auth:   config.Auth{Type: "hmac", Key: "secret"},
+			header: "X-Signature",
+			want:   "ad2221417410c28257e3a222c515d65db66a4597eaabf603a4077b36ec81e7fe",
+		},
+		{
+			name: "custom",
+			auth: config.Auth{
+				Type:            "hmac",
+				Key:             "secret",
+				Algorithm:       "sha512",
+				Encoding:        "base64",
+				Canonical:       "{{method}} {{path}}?{{query}} {{timestamp}}",
+				Signature:       "v1={{signature}}",
+				SignatureHeader: "X-Sig",
+				TimestampFormat: "unix-ms",
+			},
+			header: "X-Sig",
+			want:   "v1=dLuqfFCjqR9xVz/qyVxTJ/OspV1WmSbm3PnRItQjwup1SzhwEbvlMrWQesg7x3Ia0kwkDuKnUL7TWPksBluRCA==",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest("POST", "https://api.example.com/v1/orders?page=2", strings.NewReader(`{"name":"koi"}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := signHMAC(req, tt.auth, signedAt); err != nil {
+				t.Fatal(err)
+			}
+			if got := req.Header.Get(tt.header); got != tt.want {
+				t.Errorf("%s = %s, want %s", tt.header, got, tt.want)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package commands
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"strconv"
@@ -13,7 +14,10 @@ import (
 	"github.com/go-playground/validator/v10"
 	"github.com/killuox/koi/internal/api"
 	"github.com/killuox/koi/internal/config"
+	"github.com/killuox/koi/internal/cookies"
+	"github.com/killuox/koi/internal/env"
 	"github.com/killuox/koi/internal/output"
+	"github.com/killuox/koi/internal/samples"
 	"github.com/killuox/koi/internal/shared"
 	"github.com/killuox/koi/internal/variables"
 )
@@ -27,18 +31,129 @@ type Command struct {
 
 type Cli struct{}
 
+type builtin func(c *Cli, flags map[string]any, args []string) error
+
+var builtins = map[string]builtin{
+	"import":  (*Cli).importCmd,
+	"export":  (*Cli).exportCmd,
+	"run":     (*Cli).runCmd,
+	"config":  (*Cli).configCmd,
+	"cookies": (*Cli).cookiesCmd,
+}
+
+// Flags of built-in commands that never take a value
+var boolFlags = map[string]bool{
+	"dry-run":     true,
+	"as-curl":     true,
+	"as-httpie":   true,
+	"as-go":       true,
+	"no-validate": true,
+	"merge-data":  true,
+	"record":      true,
+}
+
 func Init() {
 	cli := &Cli{}
+	flags, positional := cli.parseArgs(os.Args[1:])
+
+	// Built-in commands take precedence over endpoints
+	if len(positional) > 0 {
+		if builtin, ok := builtins[positional[0]]; ok {
+			if err := builtin(cli, flags, positional[1:]); err != nil {
+				fmt.Printf("%s\n", err)
+				os.Exit(1)
+			}
+			os.Exit(0)
+		}
+	}
+
+	snippetFormat := cli.snippetFormat(flags)
+	cfg, vars := cli.loadConfig(flags)
+	cfg.API.Client.Resolve = append(cfg.API.Client.Resolve, cli.popList(flags, "resolve")...)
+
+	if len(positional) < 1 {
+		cli.printHelp(cfg)
+		return
+	}
+
+	data, err := cli.getData(flags)
+	if err == nil {
+		err = cli.applyNulls(flags)
+	}
+	if err != nil {
+		fmt.Printf("%s\n", err)
+		os.Exit(1)
+	}
+
+	jar, err := cookies.Load(cfg.Path, cfg.ActiveEnv)
+	if err != nil {
+		fmt.Printf("Error while loading cookies: %s\n", err)
+		os.Exit(1)
+	}
+
+	state := &shared.State{
+		Flags:      flags,
+		Cfg:        cfg,
+		Variables:  vars,
+		NoValidate: cli.popBool(flags, "no-validate"),
+		Data:       data,
+		MergeData:  cli.popBool(flags, "merge-data"),
+		Record:     cli.popBool(flags, "record"),
+		Jar:        jar,
+	}
+
+	// Endpoints of groups are subcommands, e.g. koi users list
+	cName, ep, args, ok := cfg.FindEndpoint(positional)
+	if !ok {
+		if group, isGroup := cfg.FindGroup(positional); isGroup {
+			cli.printGroupHelp(cfg, strings.Join(positional, " "), group)
+			return
+		}
+		fmt.Printf("no endpoints found for %s\n", strings.Join(positional, " "))
+		os.Exit(1)
+	}
+
+	cmd := Command{
+		name:      cName,
+		args:      args,
+		endpoint:  ep,
+		variables: vars,
+	}
+
+	if snippetFormat != "" {
+		if err := cli.printSnippet(state, cmd, snippetFormat); err != nil {
+			fmt.Printf("%s\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	err = cli.run(state, cmd)
+	if err != nil {
+		fmt.Printf("Error while running the command: %s\n", err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
 
+// loadConfig loads and validates the config, along with the variables
+// available to its templates. It exits on error
+func (c *Cli) loadConfig(flags map[string]any) (config.Config, map[string]any) {
 	vars, err := variables.GetUserVariables()
 	if err != nil {
 		fmt.Print("Error while getting user variables")
 		os.Exit(1)
 	}
 
+	cfgPath, err := config.FindPath(c.getConfigPath(flags))
+	if err != nil {
+		fmt.Printf("%s\n", err)
+		os.Exit(1)
+	}
+
 	cfg := config.Config{}
 
-	err = cfg.Init(vars)
+	err = cfg.Init(cfgPath, vars, c.getEnvName(flags))
 	if err != nil {
 		fmt.Printf("%s\n", err)
 		os.Exit(1)
@@ -46,7 +161,7 @@ func Init() {
 
 	if err := cfg.Validate(cfg); err != nil {
 		if ve, ok := err.(validator.ValidationErrors); ok {
-			fmt.Println("❌ Invalid koi.config.yaml:")
+			fmt.Printf("❌ Invalid %s:\n", cfg.Path)
 			for _, e := range ve {
 				fmt.Printf("  - %s: %s\n", e.Namespace(), cfg.CreateValidatorMessage(e))
 			}
@@ -56,39 +171,117 @@ func Init() {
 		os.Exit(1)
 	}
 
-	if len(os.Args) < 2 {
-		cli.printHelp(cfg)
-		return
+	// Static variables from the config take precedence over stored ones
+	for k, v := range cfg.Variables {
+		vars[k] = v
 	}
 
-	state := &shared.State{
-		Flags:     cli.getFlags(),
-		Cfg:       cfg,
-		Variables: vars,
+	return cfg, vars
+}
+
+// getConfigPath pops the --config flag, FindPath handles the fallbacks
+func (c *Cli) getConfigPath(flags map[string]any) string {
+	if val, ok := flags["config"]; ok {
+		delete(flags, "config")
+		return fmt.Sprintf("%v", val)
+	}
+	return ""
+}
+
+// popBool pops a boolean flag such as --dry-run
+func (c *Cli) popBool(flags map[string]any, name string) bool {
+	val, ok := flags[name]
+	if !ok {
+		return false
+	}
+	delete(flags, name)
+	switch v := val.(type) {
+	case bool:
+		return v
+	case string:
+		b, err := strconv.ParseBool(v)
+		return err != nil || b
+	default:
+		return true
 	}
+}
 
-	cName := os.Args[1]
-	args := os.Args[2:]
+// getData pops the --data flag, a raw body given inline, as @file or as - for stdin
+func (c *Cli) getData(flags map[string]any) ([]byte, error) {
+	val, ok := flags["data"]
+	if !ok {
+		return nil, nil
+	}
+	delete(flags, "data")
+
+	data := fmt.Sprintf("%v", val)
+	switch {
+	case data == "-":
+		body, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, fmt.Errorf("error reading body from stdin: %w", err)
+		}
+		return body, nil
+	case strings.HasPrefix(data, "@"):
+		body, err := os.ReadFile(data[1:])
+		if err != nil {
+			return nil, fmt.Errorf("error reading body file: %w", err)
+		}
+		return body, nil
+	default:
+		return []byte(data), nil
+	}
+}
 
-	ep, ok := cfg.Endpoints[cName]
+// applyNulls pops the --null flags, --null address.city sends address.city as null
+func (c *Cli) applyNulls(flags map[string]any) error {
+	val, ok := flags["null"]
 	if !ok {
-		fmt.Printf("no endpoints found for %s\n", cName)
-		os.Exit(1)
+		return nil
 	}
+	delete(flags, "null")
 
-	cmd := Command{
-		name:      cName,
-		args:      args,
-		endpoint:  ep,
-		variables: vars,
+	names, isList := val.([]any)
+	if !isList {
+		names = []any{val}
+	}
+	for _, name := range names {
+		field, isString := name.(string)
+		if !isString {
+			return fmt.Errorf("usage: --null <parameter>")
+		}
+		flags[field] = nil
 	}
+	return nil
+}
 
-	err = cli.run(state, cmd)
-	if err != nil {
-		fmt.Printf("Error while running the command: %s\n", err)
-		os.Exit(1)
+// popList pops a flag that can be repeated, such as --resolve
+func (c *Cli) popList(flags map[string]any, name string) []string {
+	val, ok := flags[name]
+	if !ok {
+		return nil
 	}
-	os.Exit(0)
+	delete(flags, name)
+
+	values, isList := val.([]any)
+	if !isList {
+		values = []any{val}
+	}
+	list := make([]string, len(values))
+	for i, v := range values {
+		list[i] = fmt.Sprintf("%v", v)
+	}
+	return list
+}
+
+// getEnvName pops the --env flag, falling back to the KOI_ENV variable
+func (c *Cli) getEnvName(flags map[string]any) string {
+	if val, ok := flags["env"]; ok {
+		delete(flags, "env")
+		return fmt.Sprintf("%v", val)
+	}
+	name, _ := env.GetString("KOI_ENV", "")
+	return name
 }
 
 func (c *Cli) runWithLoader(
@@ -160,14 +353,27 @@ func (c *Cli) run(s *shared.State, cmd Command) error {
 		)
 	}
 
+	// With --record the response is kept so it can be exported as an example
+	if s.Record {
+		err = samples.Save(s.Cfg.Path, cmd.name, samples.Sample{
+			Status:      result.Status,
+			ContentType: result.Headers.Get("Content-Type"),
+			Body:        string(result.Body),
+		})
+		if err != nil {
+			log.Printf("Error saving response sample: %v", err)
+		}
+	}
+
 	c.processAPIResult(result)
 	return nil
 }
 
-func (cmd *Cli) getFlags() map[string]any {
+// parseArgs splits the arguments into flags and positional arguments
+func (cmd *Cli) parseArgs(args []string) (map[string]any, []string) {
 	flagsMap := make(map[string]any)
+	var positional []string
 
-	args := os.Args[1:]
 	for i := 0; i < len(args); i++ {
 		arg := args[i]
 
@@ -177,14 +383,15 @@ func (cmd *Cli) getFlags() map[string]any {
 
 			if strings.Contains(kv, "=") {
 				parts := strings.SplitN(kv, "=", 2)
-				flagsMap[parts[0]] = parseValue(parts[1])
+				addFlag(flagsMap, parts[0], parts[1])
 			} else {
 				// If next arg exists and isn't a flag, use it as value
-				if i+1 < len(args) && !strings.HasPrefix(args[i+1], "-") {
-					flagsMap[kv] = parseValue(args[i+1])
+				// A lone - is a value, e.g. --data - reads stdin
+				if i+1 < len(args) && (!strings.HasPrefix(args[i+1], "-") || args[i+1] == "-") && !boolFlags[kv] {
+					addFlag(flagsMap, kv, args[i+1])
 					i++
 				} else {
-					flagsMap[kv] = true
+					addFlag(flagsMap, kv, true)
 				}
 			}
 
@@ -194,54 +401,113 @@ func (cmd *Cli) getFlags() map[string]any {
 
 			if strings.Contains(kv, "=") {
 				parts := strings.SplitN(kv, "=", 2)
-				flagsMap[parts[0]] = parseValue(parts[1])
+				addFlag(flagsMap, parts[0], parts[1])
 			} else {
 				if i+1 < len(args) && !strings.HasPrefix(args[i+1], "-") {
-					flagsMap[kv] = parseValue(args[i+1])
+					addFlag(flagsMap, kv, args[i+1])
 					i++
 				} else {
-					flagsMap[kv] = true
+					addFlag(flagsMap, kv, true)
 				}
 			}
+		} else {
+			positional = append(positional, arg)
 		}
 	}
 
-	return flagsMap
+	return flagsMap, positional
+}
+
+// addFlag sets a flag value, a repeated flag such as --tag a --tag b collects its values in a list
+func addFlag(flags map[string]any, key string, val any) {
+	existing, ok := flags[key]
+	if !ok {
+		flags[key] = val
+		return
+	}
+	if list, isList := existing.([]any); isList {
+		flags[key] = append(list, val)
+		return
+	}
+	flags[key] = []any{existing, val}
 }
 
 func (cmd *Cli) printHelp(cfg config.Config) {
 	fmt.Println("koi - API Testing CLI")
 	fmt.Println()
 	fmt.Println("Usage:")
-	fmt.Println("  koi <endpoint> [options]")
+	fmt.Println("  koi [--config <path>] [--env <name>] <endpoint> [options]")
+	fmt.Println("  koi <endpoint> --as-curl|--as-httpie|--as-go [options]")
+	fmt.Println("  koi <endpoint> --data <json|@file|-> [--merge-data] [options]")
+	fmt.Println("  koi <endpoint> --null <parameter> [options]")
+	fmt.Println("  koi <endpoint> --resolve <host:port:ip> [options]")
+	fmt.Println("  koi <endpoint> --record [options]")
+	fmt.Println("  koi import <format> <source> [--dry-run]")
+	fmt.Println("  koi export <format> [--output <file>]")
+	fmt.Println("  koi run <file.http>[#request]")
+	fmt.Println("  koi config show <endpoint>")
+	fmt.Println("  koi cookies list|clear|export")
 	fmt.Println()
 	fmt.Println("Available Endpoints:")
 
-	for name, ep := range cfg.Endpoints {
+	for _, name := range mapKeys(cfg.Endpoints) {
+		// Grouped endpoints are listed by koi <group>
+		if strings.Contains(name, " ") {
+			continue
+		}
+		ep := cfg.Endpoints[name]
 		fmt.Printf("  %-12s %s %s\n", name, ep.Method, ep.Path)
 	}
 
+	if len(cfg.Groups) > 0 {
+		fmt.Println()
+		fmt.Println("Available Groups:")
+		for _, name := range mapKeys(cfg.Groups) {
+			fmt.Printf("  %-12s %s\n", name, cfg.Groups[name].Description)
+		}
+	}
+
+	if len(cfg.Environments) > 0 {
+		fmt.Println()
+		fmt.Println("Available Environments:")
+		for _, name := range cfg.EnvironmentNames() {
+			fmt.Printf("  %-12s %s\n", name, cfg.Environments[name].BaseURL)
+		}
+	}
+
 	fmt.Println()
 	fmt.Println("Examples:")
 	fmt.Println("  koi login --email=user@example.com --password=secret")
 	fmt.Println("  koi health")
+	fmt.Println("  koi --env staging health")
 	fmt.Println()
 	fmt.Println("Use \"koi help <endpoint>\" for more information about an endpoint.")
 }
 
-func parseValue(val string) any {
-	// Try int
-	if i, err := strconv.Atoi(val); err == nil {
-		return i
+// printGroupHelp lists the endpoints and nested groups of a group
+func (cmd *Cli) printGroupHelp(cfg config.Config, name string, group config.Group) {
+	if group.Description != "" {
+		fmt.Printf("%s - %s\n", name, group.Description)
+	} else {
+		fmt.Println(name)
 	}
-	// Try float
-	if f, err := strconv.ParseFloat(val, 64); err == nil {
-		return f
+	fmt.Println()
+	fmt.Println("Usage:")
+	fmt.Printf("  koi %s <endpoint> [options]\n", name)
+	fmt.Println()
+	fmt.Println("Available Endpoints:")
+
+	for _, epName := range mapKeys(group.Endpoints) {
+		// The flattened endpoint has the group path, headers and defaults
+		ep := cfg.Endpoints[name+" "+epName]
+		fmt.Printf("  %-12s %-6s %-24s %s\n", epName, ep.Method, ep.Path, ep.Description)
 	}
-	// Try bool
-	if b, err := strconv.ParseBool(val); err == nil {
-		return b
+
+	if len(group.Groups) > 0 {
+		fmt.Println()
+		fmt.Println("Available Groups:")
+		for _, groupName := range mapKeys(group.Groups) {
+			fmt.Printf("  %-12s %s\n", groupName, group.Groups[groupName].Description)
+		}
 	}
-	// Default: string
-	return val
 }
@@ -0,0 +1,28 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+func (c *Cli) configCmd(flags map[string]any, args []string) error {
+	if len(args) < 2 || args[0] != "show" {
+		return fmt.Errorf("usage: koi config show <endpoint>")
+	}
+
+	cfg, _ := c.loadConfig(flags)
+	name, ep, rest, ok := cfg.FindEndpoint(args[1:])
+	if !ok || len(rest) > 0 {
+		return fmt.Errorf("no endpoints found for %s", strings.Join(args[1:], " "))
+	}
+
+	// The endpoint as sent: templates, group and environment merged in
+	data, err := yaml.Marshal(yaml.MapSlice{{Key: name, Value: ep}})
+	if err != nil {
+		return err
+	}
+	fmt.Print(string(data))
+	return nil
+}
@@ -0,0 +1,208 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/killuox/koi/internal/api"
+	"github.com/killuox/koi/internal/config"
+	"github.com/killuox/koi/internal/convert"
+	"github.com/killuox/koi/internal/samples"
+	"github.com/killuox/koi/internal/shared"
+	"github.com/killuox/koi/internal/utils"
+)
+
+// importer reads the sources given on the command line, the first one being
+// the main file or text to import
+type importer func(sources []string) (*convert.Collection, error)
+
+var importers = map[string]importer{
+	"openapi":  fromFile(convert.ImportOpenAPI),
+	"insomnia": fromFile(convert.ImportInsomnia),
+	"http":     fromFile(convert.ImportHTTPFile),
+	"curl": func(sources []string) (*convert.Collection, error) {
+		return convert.ImportCurl(strings.Join(sources, " "))
+	},
+	"postman": func(sources []string) (*convert.Collection, error) {
+		files := make([][]byte, len(sources))
+		for i, source := range sources {
+			data, err := os.ReadFile(source)
+			if err != nil {
+				return nil, fmt.Errorf("error reading %s: %w", source, err)
+			}
+			files[i] = data
+		}
+		return convert.ImportPostman(files[0], files[1:]...)
+	},
+}
+
+type exporter func(cfg config.Config) ([]byte, error)
+
+var exporters = map[string]exporter{
+	"openapi": func(cfg config.Config) ([]byte, error) {
+		recorded, err := samples.GetAll(cfg.Path)
+		if err != nil {
+			return nil, err
+		}
+		return convert.ExportOpenAPI(cfg, recorded)
+	},
+	"postman": convert.ExportPostman,
+}
+
+func fromFile(f func(data []byte) (*convert.Collection, error)) importer {
+	return func(sources []string) (*convert.Collection, error) {
+		data, err := os.ReadFile(sources[0])
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %w", sources[0], err)
+		}
+		return f(data)
+	}
+}
+
+func (c *Cli) importCmd(flags map[string]any, args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("usage: koi import <%s> <source> [--name <endpoint>] [--dry-run]", strings.Join(mapKeys(importers), "|"))
+	}
+	format, sources := args[0], args[1:]
+	dryRun := c.popBool(flags, "dry-run")
+
+	imp, ok := importers[format]
+	if !ok {
+		return fmt.Errorf("unknown import format %q, expected one of: %s", format, strings.Join(mapKeys(importers), ", "))
+	}
+
+	collection, err := imp(sources)
+	if err != nil {
+		return err
+	}
+
+	if name, ok := flags["name"]; ok && len(collection.Endpoints) == 1 {
+		collection.Endpoints[0].Name = fmt.Sprintf("%v", name)
+	}
+
+	path := c.importTarget(flags)
+	existing, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error reading %s: %w", path, err)
+	}
+
+	merged, skipped, err := collection.MergeInto(existing)
+	if err != nil {
+		return err
+	}
+
+	for _, w := range collection.Warnings {
+		fmt.Printf("⚠️  %s\n", w)
+	}
+	for _, name := range skipped {
+		fmt.Printf("Skipped %s, it already exists\n", name)
+	}
+
+	if dryRun {
+		diff := utils.Diff(string(existing), string(merged))
+		if diff == "" {
+			fmt.Printf("No changes to %s\n", path)
+			return nil
+		}
+		fmt.Printf("Changes to %s:\n%s", path, diff)
+		return nil
+	}
+
+	if err := config.WriteFile(path, merged); err != nil {
+		return err
+	}
+	fmt.Printf("Imported %d endpoints into %s\n", len(collection.Endpoints)-countPrefix(skipped, "endpoints."), path)
+	return nil
+}
+
+func (c *Cli) exportCmd(flags map[string]any, args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("usage: koi export <%s> [--output <file>]", strings.Join(mapKeys(exporters), "|"))
+	}
+
+	exp, ok := exporters[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown export format %q, expected one of: %s", args[0], strings.Join(mapKeys(exporters), ", "))
+	}
+
+	outputPath := ""
+	if val, ok := flags["output"]; ok {
+		delete(flags, "output")
+		outputPath = fmt.Sprintf("%v", val)
+	}
+
+	cfg, _ := c.loadConfig(flags)
+	data, err := exp(cfg)
+	if err != nil {
+		return err
+	}
+
+	if outputPath == "" {
+		fmt.Print(string(data))
+		return nil
+	}
+	if err := os.WriteFile(outputPath, data, 0644); err != nil {
+		return fmt.Errorf("error writing %s: %w", outputPath, err)
+	}
+	fmt.Printf("Exported %d endpoints to %s\n", len(cfg.Endpoints), outputPath)
+	return nil
+}
+
+// printSnippet prints the request an endpoint would send, without sending it
+func (c *Cli) printSnippet(s *shared.State, cmd Command, format string) error {
+	req, err := api.NewRequest(cmd.endpoint, s)
+	if err != nil {
+		return err
+	}
+
+	snippet, err := convert.Snippets[format](req)
+	if err != nil {
+		return err
+	}
+	fmt.Print(snippet)
+	return nil
+}
+
+// snippetFormat pops the --as-<format> flag, if any
+func (c *Cli) snippetFormat(flags map[string]any) string {
+	for _, format := range mapKeys(convert.Snippets) {
+		if c.popBool(flags, "as-"+format) {
+			return format
+		}
+	}
+	return ""
+}
+
+// importTarget returns the config file to merge into, a new koi.config.yaml
+// in the working directory when none exists yet
+func (c *Cli) importTarget(flags map[string]any) string {
+	explicit := c.getConfigPath(flags)
+	if explicit != "" {
+		return explicit
+	}
+	if path, err := config.FindPath(""); err == nil {
+		return path
+	}
+	return "koi.config.yaml"
+}
+
+func mapKeys[T any](m map[string]T) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func countPrefix(values []string, prefix string) int {
+	n := 0
+	for _, v := range values {
+		if strings.HasPrefix(v, prefix) {
+			n++
+		}
+	}
+	return n
+}
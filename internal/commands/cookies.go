@@ -0,0 +1,52 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/killuox/koi/internal/cookies"
+)
+
+// cookiesCmd manages the cookie jar of the current config and profile
+func (c *Cli) cookiesCmd(flags map[string]any, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: koi cookies list|clear|export")
+	}
+
+	cfg, _ := c.loadConfig(flags)
+	jar, err := cookies.Load(cfg.Path, cfg.ActiveEnv)
+	if err != nil {
+		return fmt.Errorf("error while loading cookies: %w", err)
+	}
+
+	switch args[0] {
+	case "list":
+		list := jar.List()
+		if len(list) == 0 {
+			fmt.Println("No cookies stored")
+			return nil
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "DOMAIN\tPATH\tNAME\tVALUE\tEXPIRES")
+		for _, ck := range list {
+			expires := "session"
+			if !ck.Expires.IsZero() {
+				expires = ck.Expires.Local().Format(time.DateTime)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", ck.Domain, ck.Path, ck.Name, ck.Value, expires)
+		}
+		return w.Flush()
+	case "clear":
+		if err := jar.Clear(); err != nil {
+			return fmt.Errorf("error while clearing cookies: %w", err)
+		}
+		fmt.Println("Cookies cleared")
+		return nil
+	case "export":
+		return jar.WriteNetscape(os.Stdout)
+	default:
+		return fmt.Errorf("usage: koi cookies list|clear|export")
+	}
+}
@@ -0,0 +1,235 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/killuox/koi/internal/api"
+	"github.com/killuox/koi/internal/config"
+	"github.com/killuox/koi/internal/cookies"
+	"github.com/killuox/koi/internal/httpfile"
+	"github.com/killuox/koi/internal/interpolate"
+	"github.com/killuox/koi/internal/variables"
+)
+
+var (
+	// {{login.response.body.token}} references the response of the request named login
+	responseRefRe = regexp.MustCompile(`\{\{\s*([\w-]+)\.response\.`)
+	// REST Client JSONPath bodies, {{login.response.body.$.token}}
+	jsonPathBodyRe = regexp.MustCompile(`\.response\.body\.\$\.?`)
+)
+
+func (c *Cli) runCmd(flags map[string]any, args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("usage: koi run <file.http>[#request]")
+	}
+
+	path, name := args[0], ""
+	if i := strings.LastIndex(path, "#"); i >= 0 {
+		path, name = path[:i], path[i+1:]
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %w", path, err)
+	}
+	file, err := httpfile.Parse(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	requests := file.Requests
+	if name != "" {
+		req, ok := file.Find(name)
+		if !ok {
+			return fmt.Errorf("no request named %s in %s", name, path)
+		}
+		requests, err = withDependencies(file, req)
+		if err != nil {
+			return err
+		}
+	}
+	if len(requests) == 0 {
+		return fmt.Errorf("no requests found in %s", path)
+	}
+
+	// The config is optional, when there is one its variables, profile and client settings apply
+	var vars map[string]any
+	var client config.Client
+	var tlsConfig config.TLS
+	var jar *cookies.Jar
+	_, explicit := flags["config"]
+	if _, err := config.FindPath(""); explicit || err == nil {
+		var cfg config.Config
+		cfg, vars = c.loadConfig(flags)
+		client, tlsConfig = cfg.API.Client, cfg.API.TLS
+		if jar, err = cookies.Load(cfg.Path, cfg.ActiveEnv); err != nil {
+			return fmt.Errorf("error while loading cookies: %w", err)
+		}
+	} else if vars, err = variables.GetUserVariables(); err != nil {
+		return fmt.Errorf("error while getting user variables: %w", err)
+	}
+	client.Resolve = append(client.Resolve, c.popList(flags, "resolve")...)
+
+	// File variables can reference the variable store and each other
+	for _, v := range file.Variables {
+		r := interpolate.New(vars)
+		val := r.Value(httpfile.TranslateSystemVariables(v.Value))
+		if err := r.Err(); err != nil {
+			return fmt.Errorf("@%s: %w", v.Name, err)
+		}
+		vars[v.Name] = val
+	}
+
+	r := interpolate.New(vars)
+	tlsConfig.Password = r.String(tlsConfig.Password)
+	if err := r.Err(); err != nil {
+		return fmt.Errorf("tls password: %w", err)
+	}
+
+	for _, req := range requests {
+		result, err := c.runWithLoader(func() (api.Result, error) {
+			httpReq, err := newHTTPFileRequest(req, vars, filepath.Dir(path))
+			if err != nil {
+				return api.Result{}, err
+			}
+
+			// Cookies are kept along with the config, and only when there is one
+			var cookieJar http.CookieJar
+			if jar != nil && !req.NoCookieJar {
+				cookieJar = jar
+			}
+
+			startTime := time.Now()
+			result, err := api.Send(httpReq, client, tlsConfig, cookieJar)
+			result.Duration = time.Since(startTime)
+			if err == nil && cookieJar != nil {
+				err = jar.Save()
+			}
+			return result, err
+		})
+		if err != nil {
+			return fmt.Errorf("error while running %s (line %d): %w", req.DisplayName(), req.Line, err)
+		}
+
+		if req.Name != "" {
+			vars[req.Name] = captureResponse(result)
+		}
+		c.processAPIResult(result)
+	}
+
+	return nil
+}
+
+func newHTTPFileRequest(req httpfile.Request, vars map[string]any, dir string) (*http.Request, error) {
+	r := interpolate.New(vars)
+	render := func(s string) string {
+		s = jsonPathBodyRe.ReplaceAllString(s, ".response.body.")
+		return r.String(httpfile.TranslateSystemVariables(s))
+	}
+
+	url := render(req.URL)
+	body := render(req.Body)
+
+	// < ./payload.json sends the content of a file, <@ also renders its variables
+	if strings.HasPrefix(body, "< ") || strings.HasPrefix(body, "<@ ") {
+		path := strings.TrimSpace(body[strings.Index(body, " "):])
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading body file: %w", err)
+		}
+		body = string(data)
+		if strings.HasPrefix(req.Body, "<@") {
+			body = render(body)
+		}
+	}
+	headers := make([]httpfile.Header, len(req.Headers))
+	for i, h := range req.Headers {
+		headers[i] = httpfile.Header{Name: h.Name, Value: render(h.Value)}
+	}
+	if err := r.Err(); err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequest(req.Method, url, strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range headers {
+		httpReq.Header.Add(h.Name, h.Value)
+	}
+	return httpReq, nil
+}
+
+// captureResponse exposes a response to later requests as {{name.response.*}}
+func captureResponse(result api.Result) map[string]any {
+	headers := map[string]any{}
+	for name, values := range result.Headers {
+		headers[name] = strings.Join(values, ", ")
+	}
+
+	var body any = string(result.Body)
+	var decoded any
+	if err := json.Unmarshal(result.Body, &decoded); err == nil {
+		body = decoded
+	}
+
+	return map[string]any{
+		"response": map[string]any{
+			"status":  result.Status,
+			"headers": headers,
+			"body":    body,
+		},
+	}
+}
+
+// withDependencies returns req preceded by the named requests whose responses it uses
+func withDependencies(file *httpfile.File, req httpfile.Request) ([]httpfile.Request, error) {
+	var ordered []httpfile.Request
+	visiting := map[string]bool{}
+	done := map[string]bool{}
+
+	var visit func(r httpfile.Request) error
+	visit = func(r httpfile.Request) error {
+		key := r.DisplayName()
+		if done[key] {
+			return nil
+		}
+		if visiting[key] {
+			return fmt.Errorf("request %s depends on itself", key)
+		}
+		visiting[key] = true
+
+		text := r.URL + "\n" + r.Body
+		for _, h := range r.Headers {
+			text += "\n" + h.Value
+		}
+		for _, m := range responseRefRe.FindAllStringSubmatch(text, -1) {
+			dep, ok := file.Find(m[1])
+			if !ok || dep.Name != m[1] {
+				continue
+			}
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+
+		done[key] = true
+		ordered = append(ordered, r)
+		return nil
+	}
+
+	if err := visit(req); err != nil {
+		return nil, err
+	}
+	return ordered, nil
+}
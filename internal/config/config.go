@@ -1,63 +1,293 @@
 package config
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/brianvoe/gofakeit/v7"
 	"github.com/go-playground/validator/v10"
 	"github.com/killuox/koi/internal/env"
+	"github.com/killuox/koi/internal/interpolate"
 	"gopkg.in/yaml.v2"
 )
 
 type Config struct {
-	API       API                 `yaml:"api" validate:"required"`
-	Endpoints map[string]Endpoint `yaml:"endpoints" validate:"required,dive"`
+	Include      []string               `yaml:"include,omitempty"`
+	API          API                    `yaml:"api" validate:"required"`
+	Environments map[string]Environment `yaml:"environments,omitempty" validate:"dive"`
+	Variables    map[string]any         `yaml:"variables,omitempty"`
+	Endpoints    map[string]Endpoint    `yaml:"endpoints" validate:"required,dive"`
+	Groups       map[string]Group       `yaml:"groups,omitempty"`
+	Templates    map[string]Endpoint    `yaml:"templates,omitempty"`
+	// Path of the main config file, set by Init
+	Path string `yaml:"-"`
+	// Name of the environment profile applied by Init, empty when none
+	ActiveEnv string `yaml:"-"`
 }
 
 type API struct {
-	BaseURL string            `yaml:"baseUrl" validate:"required,url"`
-	Headers map[string]string `yaml:"headers"`
+	// An http(s) URL, or http+unix:///path/to.sock for a service on a unix socket
+	BaseURL string            `yaml:"baseUrl" validate:"required,baseurl"`
+	Headers map[string]string `yaml:"headers,omitempty"`
+	Client  Client            `yaml:"client,omitempty"`
+	TLS     TLS               `yaml:"tls,omitempty"`
+	Auth    Auth              `yaml:"auth,omitempty"`
 }
 
+// Auth authenticates the requests. Endpoints use the api one unless they
+// set their own, or `auth: none` for public routes
+type Auth struct {
+	Type string `yaml:"type" validate:"omitempty,oneof=none basic bearer api-key digest oauth2 hmac aws-sigv4"`
+	// basic, digest and the oauth2 password grant
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	// bearer
+	Token string `yaml:"token,omitempty"`
+	// api-key, sent as the Name header (X-API-Key by default) or query parameter (api_key),
+	// and the secret of hmac
+	Key  string `yaml:"key,omitempty"`
+	Name string `yaml:"name,omitempty"`
+	In   string `yaml:"in,omitempty" validate:"omitempty,oneof=header query"`
+	// oauth2, the tokens are cached in ~/.koi/tokens.json until they expire
+	Grant        string `yaml:"grant,omitempty" validate:"omitempty,oneof=client_credentials password refresh_token authorization_code authorization_code+pkce"`
+	TokenURL     string `yaml:"token-url,omitempty"`
+	ClientID     string `yaml:"client-id,omitempty"`
+	ClientSecret string `yaml:"client-secret,omitempty"`
+	// Client credentials sent as basic auth (default) or in the token request body
+	ClientAuth   string   `yaml:"client-auth,omitempty" validate:"omitempty,oneof=basic body"`
+	Scopes       []string `yaml:"scopes,omitempty"`
+	RefreshToken string   `yaml:"refresh-token,omitempty"`
+	// authorization_code, always with PKCE so authorization_code+pkce is an alias, the
+	// redirect goes to a loopback listener (a free port by default)
+	AuthURL     string `yaml:"auth-url,omitempty"`
+	RedirectURL string `yaml:"redirect-url,omitempty"`
+	// hmac, the canonical and signature templates are rendered with the request
+	// method, path, query, host, timestamp, nonce, body, body_sha256 and header.<name>
+	Algorithm       string `yaml:"algorithm,omitempty" validate:"omitempty,oneof=sha256 sha512 sha1"`
+	Canonical       string `yaml:"canonical,omitempty"`
+	Encoding        string `yaml:"encoding,omitempty" validate:"omitempty,oneof=hex base64"`
+	Signature       string `yaml:"signature,omitempty"`
+	SignatureHeader string `yaml:"signature-header,omitempty"`
+	TimestampHeader string `yaml:"timestamp-header,omitempty"`
+	TimestampFormat string `yaml:"timestamp-format,omitempty" validate:"omitempty,oneof=unix unix-ms rfc3339"`
+	// aws-sigv4, the credentials default to the AWS_* env variables
+	Service         string `yaml:"service,omitempty"`
+	Region          string `yaml:"region,omitempty"`
+	AccessKeyID     string `yaml:"access-key-id,omitempty"`
+	SecretAccessKey string `yaml:"secret-access-key,omitempty"`
+	SessionToken    string `yaml:"session-token,omitempty"`
+}
+
+// UnmarshalYAML accepts the type alone, e.g. `auth: none`
+func (a *Auth) UnmarshalYAML(unmarshal func(any) error) error {
+	var authType string
+	if err := unmarshal(&authType); err == nil {
+		*a = Auth{Type: authType}
+		return nil
+	}
+	type plain Auth
+	return unmarshal((*plain)(a))
+}
+
+// MarshalYAML writes `auth: none` back as the type alone
+func (a Auth) MarshalYAML() (any, error) {
+	if a.Type == "none" {
+		return a.Type, nil
+	}
+	type plain Auth
+	return plain(a), nil
+}
+
+// TLS configures the TLS connections, paths are relative to the config file
+type TLS struct {
+	// PEM bundle of the CAs trusted along with the system ones
+	CA string `yaml:"ca,omitempty"`
+	// Client certificate, a PEM file along with its key, or a PKCS#12 (.p12, .pfx) file
+	Cert     string `yaml:"cert,omitempty"`
+	Key      string `yaml:"key,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	// Name checked against the server certificate, and sent as SNI
+	ServerName         string `yaml:"server-name,omitempty"`
+	MinVersion         string `yaml:"min-version,omitempty" validate:"omitempty,oneof=1.0 1.1 1.2 1.3"`
+	InsecureSkipVerify bool   `yaml:"insecure-skip-verify,omitempty"`
+}
+
+// Merge returns t with the settings of override taking precedence
+func (t TLS) Merge(override TLS) TLS {
+	if override.CA != "" {
+		t.CA = override.CA
+	}
+	// The certificate, key and password go together
+	if override.Cert != "" {
+		t.Cert, t.Key, t.Password = override.Cert, override.Key, override.Password
+	}
+	if override.ServerName != "" {
+		t.ServerName = override.ServerName
+	}
+	if override.MinVersion != "" {
+		t.MinVersion = override.MinVersion
+	}
+	if override.InsecureSkipVerify {
+		t.InsecureSkipVerify = true
+	}
+	return t
+}
+
+// inDir resolves the relative paths of t from dir
+func (t TLS) inDir(dir string) TLS {
+	for _, path := range []*string{&t.CA, &t.Cert, &t.Key} {
+		if *path != "" && !filepath.IsAbs(*path) {
+			*path = filepath.Join(dir, *path)
+		}
+	}
+	return t
+}
+
+// Client configures how requests are sent, endpoints can override any setting
+type Client struct {
+	// Time to connect, to wait for the response headers, and for the whole request
+	ConnectTimeout time.Duration `yaml:"connect-timeout,omitempty" validate:"gte=0"`
+	ReadTimeout    time.Duration `yaml:"read-timeout,omitempty" validate:"gte=0"`
+	Timeout        time.Duration `yaml:"timeout,omitempty" validate:"gte=0"`
+	// follow (default), none or the maximum number of redirects to follow
+	Redirects string `yaml:"redirects,omitempty" validate:"omitempty,redirects"`
+	// http(s):// or socks5:// proxy, HTTP_PROXY, HTTPS_PROXY and NO_PROXY apply otherwise
+	Proxy   string `yaml:"proxy,omitempty" validate:"omitempty,url"`
+	NoProxy string `yaml:"no-proxy,omitempty"`
+	// host:port:ip entries sending requests for host:port to ip, like curl --resolve
+	Resolve []string `yaml:"resolve,omitempty"`
+}
+
+// Merge returns c with the settings of override taking precedence, resolve
+// entries are combined
+func (c Client) Merge(override Client) Client {
+	if override.ConnectTimeout != 0 {
+		c.ConnectTimeout = override.ConnectTimeout
+	}
+	if override.ReadTimeout != 0 {
+		c.ReadTimeout = override.ReadTimeout
+	}
+	if override.Timeout != 0 {
+		c.Timeout = override.Timeout
+	}
+	if override.Redirects != "" {
+		c.Redirects = override.Redirects
+	}
+	if override.Proxy != "" {
+		c.Proxy = override.Proxy
+	}
+	if override.NoProxy != "" {
+		c.NoProxy = override.NoProxy
+	}
+	c.Resolve = append(slices.Clone(c.Resolve), override.Resolve...)
+	return c
+}
+
+// Environment is a named profile overriding parts of the API config
+type Environment struct {
+	BaseURL   string            `yaml:"baseUrl,omitempty" validate:"omitempty,baseurl"`
+	Headers   map[string]string `yaml:"headers,omitempty"`
+	Defaults  map[string]any    `yaml:"defaults,omitempty"`
+	Variables map[string]any    `yaml:"variables,omitempty"`
+	TLS       TLS               `yaml:"tls,omitempty"`
+}
+
+// SetVariableConfig stores parts of the response as variables. Sources may be
+// piped through transforms, e.g. `Location | regex '/users/(\d+)'`
 type SetVariableConfig struct {
-	Body map[string]any `yaml:"body"`
+	// Variables set from JSONPath expressions of the body, the leading $ is optional
+	Body map[string]string `yaml:"body,omitempty"`
+	// Variables set from the headers of the response, by header name
+	Headers map[string]string `yaml:"headers,omitempty"`
+	// Variables set from the cookies of the response, by cookie name
+	Cookies map[string]string `yaml:"cookies,omitempty"`
+	// Variable set to the status code of the response
+	Status string `yaml:"status,omitempty"`
+}
+
+// Group nests endpoints under a subcommand, e.g. `koi users list`. Its path
+// prefixes the paths of its endpoints, and its headers and defaults apply to
+// all of them unless an endpoint overrides them
+type Group struct {
+	Description string              `yaml:"description,omitempty"`
+	Path        string              `yaml:"path,omitempty"`
+	Headers     map[string]string   `yaml:"headers,omitempty"`
+	Defaults    map[string]any      `yaml:"defaults,omitempty"`
+	Endpoints   map[string]Endpoint `yaml:"endpoints,omitempty"`
+	Groups      map[string]Group    `yaml:"groups,omitempty"`
 }
 
 type Endpoint struct {
-	Method       string               `yaml:"method" validate:"required,oneof=GET POST PUT PATCH DELETE"`
-	Path         string               `yaml:"path" validate:"required"`
-	Mode         string               `yaml:"mode" validate:"omitempty,oneof=env faker"`
-	Parameters   map[string]Parameter `yaml:"parameters" validate:"dive"`
-	Defaults     map[string]any       `yaml:"defaults"`
-	SetVariables SetVariableConfig    `yaml:"set-variables"`
+	// Templates the endpoint inherits from, in order, resolved by Init
+	Extends     []string          `yaml:"extends,omitempty"`
+	Method      string            `yaml:"method" validate:"required,method"`
+	Path        string            `yaml:"path" validate:"required"`
+	Description string            `yaml:"description,omitempty"`
+	Headers     map[string]string `yaml:"headers,omitempty"`
+	BodyType    string            `yaml:"body-type,omitempty" validate:"omitempty,oneof=json form multipart raw xml"`
+	// File rendered as the body, relative to the config file
+	BodyTemplate string               `yaml:"body-template,omitempty"`
+	Mode         string               `yaml:"mode,omitempty" validate:"omitempty,oneof=env faker"`
+	Parameters   map[string]Parameter `yaml:"parameters,omitempty" validate:"dive"`
+	Defaults     map[string]any       `yaml:"defaults,omitempty"`
+	SetVariables SetVariableConfig    `yaml:"set-variables,omitempty"`
+	// Override the api client, TLS and auth settings
+	Client Client `yaml:"client,omitempty"`
+	TLS    TLS    `yaml:"tls,omitempty"`
+	Auth   Auth   `yaml:"auth,omitempty"`
+	// Send and store the cookies of the jar, true unless set to false
+	Cookies *bool `yaml:"cookies,omitempty"`
 }
 
 type Parameter struct {
-	Type        string `yaml:"type" validate:"required,oneof=string int bool float"`
-	Mode        string `yaml:"mode"`
-	In          string `yaml:"in" validate:"omitempty,oneof=query path body"`
-	Description string `yaml:"description"`
-	Required    bool   `yaml:"required"`
-	Rules       Rules  `yaml:"rules"`
+	Type        string `yaml:"type" validate:"required,oneof=string int bool float object array file"`
+	Mode        string `yaml:"mode,omitempty"`
+	In          string `yaml:"in,omitempty" validate:"omitempty,oneof=query path body header cookie"`
+	Description string `yaml:"description,omitempty"`
+	Required    bool   `yaml:"required,omitempty"`
+	// Null is a valid value, e.g. to clear a field with --null
+	Nullable bool `yaml:"nullable,omitempty"`
+	// Send null when there is no value instead of leaving the parameter out
+	SendNull bool  `yaml:"send-null,omitempty"`
+	Rules    Rules `yaml:"rules,omitempty"`
+	// How list values are sent in the query: repeat (default), comma or brackets
+	Style string `yaml:"style,omitempty" validate:"omitempty,oneof=repeat comma brackets"`
+	// Fields of an object
+	Properties map[string]Parameter `yaml:"properties,omitempty" validate:"dive"`
+	// Schema of the items of an array
+	Items *Parameter `yaml:"items,omitempty"`
 }
 
 type Rules struct {
 	// For strings
-	MinLength int `yaml:"min_length" validate:"gte=0"`
-	MaxLength int `yaml:"max_length" validate:"gte=0"`
+	MinLength int `yaml:"min_length,omitempty" validate:"gte=0"`
+	MaxLength int `yaml:"max_length,omitempty" validate:"gte=0"`
 	// Faker mode - Image
-	Width  int `yaml:"width" validate:"gte=0"`
-	Height int `yaml:"height" validate:"gte=0"`
+	Width  int `yaml:"width,omitempty" validate:"gte=0"`
+	Height int `yaml:"height,omitempty" validate:"gte=0"`
 	// Faker mode - For paragraph and sentence
-	ParagraphCount int `yaml:"paragraph_count" validate:"gte=0"`
-	SentenceCount  int `yaml:"sentence_count" validate:"gte=0"`
-	WordCount      int `yaml:"word_count" validate:"gte=0"`
-	// Faker mode - For numbers
-	Min int `yaml:"min"`
-	Max int `yaml:"max"`
+	ParagraphCount int `yaml:"paragraph_count,omitempty" validate:"gte=0"`
+	SentenceCount  int `yaml:"sentence_count,omitempty" validate:"gte=0"`
+	WordCount      int `yaml:"word_count,omitempty" validate:"gte=0"`
+	// Faker mode - For numbers, and checked before sending. Pointers so that
+	// a zero bound is still checked
+	Min *int `yaml:"min,omitempty"`
+	Max *int `yaml:"max,omitempty"`
+	// For arrays, the number of items generated from the items schema
+	Count int `yaml:"count,omitempty" validate:"gte=0"`
+	// Checked before sending
+	Enum    []any  `yaml:"enum,omitempty"`
+	Pattern string `yaml:"pattern,omitempty"`
+	Format  string `yaml:"format,omitempty" validate:"omitempty,oneof=uuid email date date-time url"`
 }
 
 // ENV
@@ -92,6 +322,10 @@ type FakerNumberParam struct{}
 type FakerImageParam struct{}
 type FakerSentenceParam struct{}
 type FakerParagraphParam struct{}
+type FakerUUIDParam struct{}
+type FakerURLParam struct{}
+type FakerDateParam struct{}
+type FakerDateTimeParam struct{}
 
 var fakerParamTypeRegistry = map[string]FakerValueGetter{
 	"full_name":   FakerFullNameParam{},
@@ -106,45 +340,517 @@ var fakerParamTypeRegistry = map[string]FakerValueGetter{
 	"image":       FakerImageParam{},
 	"sentence":    FakerSentenceParam{},
 	"paragraph":   FakerParagraphParam{},
+	"uuid":        FakerUUIDParam{},
+	"url":         FakerURLParam{},
+	"date":        FakerDateParam{},
+	"date_time":   FakerDateTimeParam{},
+}
+
+// Standard methods, endpoints can also use custom ones such as PURGE or PROPFIND
+var Methods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD", "OPTIONS", "TRACE"}
+
+// UnixScheme prefixes the socket path of base URLs of services on a unix socket
+const UnixScheme = "http+unix://"
+
+var methodRe = regexp.MustCompile(`^[A-Z][A-Z0-9_-]*$`)
+
+// ValidMethod reports whether m is a standard or custom uppercase method
+func ValidMethod(m string) bool {
+	return slices.Contains(Methods, m) || methodRe.MatchString(m)
+}
+
+// HasBody reports whether the endpoint sends a body, POST, PUT and PATCH
+// always do, other methods when a body-type or body-template is configured
+func (e Endpoint) HasBody() bool {
+	switch e.Method {
+	case "POST", "PUT", "PATCH":
+		return true
+	default:
+		return e.BodyType != "" || e.BodyTemplate != ""
+	}
+}
+
+// UsesCookies reports whether the endpoint sends and stores the cookies of the jar
+func (e Endpoint) UsesCookies() bool {
+	return e.Cookies == nil || *e.Cookies
 }
 
 // Config
-func (c *Config) Init(vars map[string]any) (err error) {
-	yamlFile, err := os.ReadFile("koi.config.yaml")
+var configFileNames = []string{"koi.config.yaml", "koi.config.yml"}
+
+// FindPath returns the config file to load: the explicit path when given,
+// then KOI_CONFIG, then the first koi.config.yaml found walking up from the
+// working directory
+func FindPath(explicit string) (string, error) {
+	if explicit == "" {
+		explicit, _ = env.GetString("KOI_CONFIG", "")
+	}
+	if explicit != "" {
+		if _, err := os.Stat(explicit); err != nil {
+			return "", fmt.Errorf("config file %s not found", explicit)
+		}
+		return explicit, nil
+	}
+
+	wd, err := os.Getwd()
 	if err != nil {
-		return fmt.Errorf("error reading koi.config.yaml file")
+		return "", fmt.Errorf("error getting working directory: %w", err)
 	}
 
-	// Regex to find {{variable}}
-	re := regexp.MustCompile(`\{\{(\w+)\}\}`)
+	for dir := wd; ; {
+		for _, name := range configFileNames {
+			path := filepath.Join(dir, name)
+			if _, err := os.Stat(path); err == nil {
+				return path, nil
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+
+	return "", fmt.Errorf("could not find koi.config.yaml in %s or any parent directory", wd)
+}
 
-	// Replace all placeholders
-	newYamlString := re.ReplaceAllStringFunc(string(yamlFile), func(match string) string {
-		// Extract the key without {{}}
-		key := strings.Trim(match, "{}")
-		key = strings.TrimSpace(key)
+func (c *Config) Init(path string, vars map[string]any, envName string) (err error) {
+	yamlFile, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading %s file", path)
+	}
+
+	err = yaml.Unmarshal(yamlFile, c)
+	if err != nil {
+		return fmt.Errorf("error unmarshaling config file: %w", err)
+	}
+	c.Path = path
 
-		// Lookup the key in vars
-		if val, ok := vars[key]; ok {
-			return fmt.Sprintf("%v", val)
+	if err := c.loadIncludes(); err != nil {
+		return err
+	}
+
+	if err := c.applyTemplates(); err != nil {
+		return err
+	}
+
+	if err := c.flattenGroups(); err != nil {
+		return err
+	}
+
+	staticVars, err := c.resolveVariables(envName)
+	if err != nil {
+		return err
+	}
+	c.Variables = staticVars
+
+	if err := c.applyEnvironment(envName); err != nil {
+		return err
+	}
+
+	c.API.TLS = c.API.TLS.inDir(c.Dir())
+	for name, ep := range c.Endpoints {
+		ep.TLS = ep.TLS.inDir(c.Dir())
+		c.Endpoints[name] = ep
+	}
+
+	// The base URL is needed by every endpoint, resolve it upfront
+	allVars := make(map[string]any, len(vars)+len(staticVars))
+	for k, v := range vars {
+		allVars[k] = v
+	}
+	for k, v := range staticVars {
+		allVars[k] = v
+	}
+	r := interpolate.New(allVars)
+	c.API.BaseURL = r.String(c.API.BaseURL)
+	if err := r.Err(); err != nil {
+		return fmt.Errorf("error resolving api.baseUrl: %w", err)
+	}
+
+	return nil
+}
+
+// Dir returns the directory of the main config file, which relative paths are resolved from
+func (c *Config) Dir() string {
+	return filepath.Dir(c.Path)
+}
+
+// loadIncludes merges the endpoints and groups of every file matched by the include globs
+func (c *Config) loadIncludes() error {
+	if len(c.Include) == 0 {
+		return nil
+	}
+
+	if c.Endpoints == nil {
+		c.Endpoints = make(map[string]Endpoint)
+	}
+	if c.Groups == nil {
+		c.Groups = make(map[string]Group)
+	}
+	origins := make(map[string]string, len(c.Endpoints))
+	for name := range c.Endpoints {
+		origins[name] = c.Path
+	}
+	groupOrigins := make(map[string]string, len(c.Groups))
+	for name := range c.Groups {
+		groupOrigins[name] = c.Path
+	}
+	if c.Templates == nil {
+		c.Templates = make(map[string]Endpoint)
+	}
+	templateOrigins := make(map[string]string, len(c.Templates))
+	for name := range c.Templates {
+		templateOrigins[name] = c.Path
+	}
+
+	mainPath, _ := filepath.Abs(c.Path)
+	for _, pattern := range c.Include {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(c.Dir(), pattern)
 		}
-		// If not found, keep original
-		return match
-	})
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid include pattern %s: %w", pattern, err)
+		}
+		if len(matches) == 0 && !strings.ContainsAny(pattern, "*?[") {
+			return fmt.Errorf("included file %s not found", pattern)
+		}
+
+		for _, file := range matches {
+			if abs, _ := filepath.Abs(file); abs == mainPath {
+				continue
+			}
+
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return fmt.Errorf("error reading included file %s: %w", file, err)
+			}
+			included := Config{}
+			if err := yaml.Unmarshal(data, &included); err != nil {
+				return fmt.Errorf("error unmarshaling included file %s: %w", file, err)
+			}
+
+			for name, ep := range included.Endpoints {
+				if origin, exists := origins[name]; exists {
+					return fmt.Errorf("duplicate endpoint %q in %s (already defined in %s)", name, file, origin)
+				}
+				origins[name] = file
+				c.Endpoints[name] = ep
+			}
+			for name, g := range included.Groups {
+				if origin, exists := groupOrigins[name]; exists {
+					return fmt.Errorf("duplicate group %q in %s (already defined in %s)", name, file, origin)
+				}
+				groupOrigins[name] = file
+				c.Groups[name] = g
+			}
+			for name, t := range included.Templates {
+				if origin, exists := templateOrigins[name]; exists {
+					return fmt.Errorf("duplicate template %q in %s (already defined in %s)", name, file, origin)
+				}
+				templateOrigins[name] = file
+				c.Templates[name] = t
+			}
+		}
+	}
+
+	return nil
+}
+
+// applyTemplates merges the templates extended by every endpoint into it
+func (c *Config) applyTemplates() error {
+	for name, ep := range c.Endpoints {
+		extended, err := c.extend(ep, nil)
+		if err != nil {
+			return fmt.Errorf("endpoint %q: %w", name, err)
+		}
+		c.Endpoints[name] = extended
+	}
+	return nil
+}
 
-	// Convert back to byte
-	yamlByte := []byte(newYamlString)
+// extend deep merges the templates of an endpoint in order, then the endpoint
+// itself, so later templates override earlier ones and the endpoint wins.
+// Templates can extend other templates, chain holds the ones being resolved
+func (c *Config) extend(ep Endpoint, chain []string) (Endpoint, error) {
+	if len(ep.Extends) == 0 {
+		return ep, nil
+	}
 
-	err = yaml.Unmarshal(yamlByte, c)
+	var merged any
+	for _, name := range ep.Extends {
+		if slices.Contains(chain, name) {
+			return Endpoint{}, fmt.Errorf("template %q extends itself (%s)", name, strings.Join(append(chain, name), " -> "))
+		}
+		t, ok := c.Templates[name]
+		if !ok {
+			return Endpoint{}, fmt.Errorf("unknown template %q", name)
+		}
+		t, err := c.extend(t, append(chain, name))
+		if err != nil {
+			return Endpoint{}, err
+		}
+		tm, err := toYAMLValue(t)
+		if err != nil {
+			return Endpoint{}, err
+		}
+		merged = deepMerge(merged, tm)
+	}
+
+	ep.Extends = nil
+	em, err := toYAMLValue(ep)
 	if err != nil {
-		return fmt.Errorf("error unmarshaling config file: %w", err)
+		return Endpoint{}, err
+	}
+	merged = deepMerge(merged, em)
+
+	data, err := yaml.Marshal(merged)
+	if err != nil {
+		return Endpoint{}, err
+	}
+	extended := Endpoint{}
+	if err := yaml.Unmarshal(data, &extended); err != nil {
+		return Endpoint{}, err
+	}
+	return extended, nil
+}
+
+// toYAMLValue converts v to the generic maps yaml unmarshals into
+func toYAMLValue(v any) (any, error) {
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var out any
+	err = yaml.Unmarshal(data, &out)
+	return out, err
+}
+
+// deepMerge merges override into base. Maps are merged key by key, any other
+// value replaces the base one unless it is empty
+func deepMerge(base, override any) any {
+	if override == nil || override == "" {
+		return base
+	}
+	baseMap, baseIsMap := base.(map[any]any)
+	overrideMap, overrideIsMap := override.(map[any]any)
+	if !baseIsMap || !overrideIsMap {
+		return override
+	}
+
+	merged := make(map[any]any, len(baseMap)+len(overrideMap))
+	for k, v := range baseMap {
+		merged[k] = v
+	}
+	for k, v := range overrideMap {
+		merged[k] = deepMerge(baseMap[k], v)
+	}
+	return merged
+}
+
+// flattenGroups adds the endpoints of every group to Endpoints, named after
+// their subcommand, e.g. "users list", with the group path, headers and defaults applied
+func (c *Config) flattenGroups() error {
+	if len(c.Groups) == 0 {
+		return nil
+	}
+	if c.Endpoints == nil {
+		c.Endpoints = make(map[string]Endpoint)
 	}
+	for name := range c.Groups {
+		if _, exists := c.Endpoints[name]; exists {
+			return fmt.Errorf("group %q has the same name as an endpoint", name)
+		}
+	}
+	return c.addGroups(c.Groups, "", Group{})
+}
+
+func (c *Config) addGroups(groups map[string]Group, prefix string, parent Group) error {
+	for name, g := range groups {
+		name = prefix + name
+		g.Path = joinPath(parent.Path, g.Path)
+		g.Headers = mergeMaps(parent.Headers, g.Headers)
+		g.Defaults = mergeMaps(parent.Defaults, g.Defaults)
+
+		for epName, ep := range g.Endpoints {
+			fullName := name + " " + epName
+			ep, err := c.extend(ep, nil)
+			if err != nil {
+				return fmt.Errorf("endpoint %q: %w", fullName, err)
+			}
+			if _, exists := c.Endpoints[fullName]; exists {
+				return fmt.Errorf("duplicate endpoint %q in group %q", epName, name)
+			}
+			if _, exists := g.Groups[epName]; exists {
+				return fmt.Errorf("group %q has an endpoint and a group named %q", name, epName)
+			}
+			ep.Path = joinPath(g.Path, ep.Path)
+			ep.Headers = mergeMaps(g.Headers, ep.Headers)
+			ep.Defaults = mergeMaps(g.Defaults, ep.Defaults)
+			c.Endpoints[fullName] = ep
+		}
 
+		if err := c.addGroups(g.Groups, name+" ", g); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// joinPath appends an endpoint path to the path prefix of its group
+func joinPath(prefix, path string) string {
+	if path == "" || prefix == "" {
+		return prefix + path
+	}
+	return strings.TrimSuffix(prefix, "/") + "/" + strings.TrimPrefix(path, "/")
+}
+
+// mergeMaps returns base overridden by override, without modifying either
+func mergeMaps[T any](base, override map[string]T) map[string]T {
+	if len(base) == 0 {
+		return override
+	}
+	merged := make(map[string]T, len(base)+len(override))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range override {
+		merged[k] = v
+	}
+	return merged
+}
+
+// FindEndpoint resolves the subcommand in args to an endpoint, e.g. users
+// list, and returns its name along with the remaining args
+func (c *Config) FindEndpoint(args []string) (string, Endpoint, []string, bool) {
+	for i := len(args); i > 0; i-- {
+		name := strings.Join(args[:i], " ")
+		if ep, ok := c.Endpoints[name]; ok {
+			return name, ep, args[i:], true
+		}
+	}
+	return "", Endpoint{}, args, false
+}
+
+// FindGroup returns the group the args name, e.g. users or admin users
+func (c *Config) FindGroup(args []string) (Group, bool) {
+	groups := c.Groups
+	var g Group
+	for i, name := range args {
+		found, ok := groups[name]
+		if !ok {
+			return Group{}, false
+		}
+		g = found
+		if i < len(args)-1 {
+			groups = g.Groups
+		}
+	}
+	return g, len(args) > 0
+}
+
+// resolveVariables merges the top-level static variables with the ones of the
+// selected environment profile
+func (c *Config) resolveVariables(envName string) (map[string]any, error) {
+	vars := make(map[string]any)
+	for k, v := range c.Variables {
+		vars[k] = v
+	}
+	if envName == "" {
+		return vars, nil
+	}
+
+	environment, err := c.GetEnvironment(envName)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range environment.Variables {
+		vars[k] = v
+	}
+	return vars, nil
+}
+
+// applyEnvironment overrides the API and endpoints with the selected profile
+func (c *Config) applyEnvironment(envName string) error {
+	if envName == "" {
+		return nil
+	}
+
+	environment, err := c.GetEnvironment(envName)
+	if err != nil {
+		return err
+	}
+
+	if environment.BaseURL != "" {
+		c.API.BaseURL = environment.BaseURL
+	}
+
+	c.API.TLS = c.API.TLS.Merge(environment.TLS)
+
+	if len(environment.Headers) > 0 {
+		headers := make(map[string]string, len(c.API.Headers)+len(environment.Headers))
+		for k, v := range c.API.Headers {
+			headers[k] = v
+		}
+		for k, v := range environment.Headers {
+			headers[k] = v
+		}
+		c.API.Headers = headers
+	}
+
+	if len(environment.Defaults) > 0 {
+		for name, ep := range c.Endpoints {
+			defaults := make(map[string]any, len(ep.Defaults)+len(environment.Defaults))
+			for k, v := range ep.Defaults {
+				defaults[k] = v
+			}
+			for k, v := range environment.Defaults {
+				defaults[k] = v
+			}
+			ep.Defaults = defaults
+			c.Endpoints[name] = ep
+		}
+	}
+
+	c.ActiveEnv = envName
+	return nil
+}
+
+func (c *Config) GetEnvironment(name string) (Environment, error) {
+	environment, ok := c.Environments[name]
+	if !ok {
+		return Environment{}, fmt.Errorf("unknown environment %q (available: %s)", name, strings.Join(c.EnvironmentNames(), ", "))
+	}
+	return environment, nil
+}
+
+func (c *Config) EnvironmentNames() []string {
+	names := make([]string, 0, len(c.Environments))
+	for name := range c.Environments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 func (c *Config) Validate(cfg Config) error {
 	validate := validator.New()
+	validate.RegisterValidation("method", func(fl validator.FieldLevel) bool {
+		return ValidMethod(fl.Field().String())
+	})
+	validate.RegisterValidation("baseurl", func(fl validator.FieldLevel) bool {
+		v := fl.Field().String()
+		if socket, ok := strings.CutPrefix(v, UnixScheme); ok {
+			return strings.HasPrefix(socket, "/")
+		}
+		return validate.Var(v, "url") == nil
+	})
+	validate.RegisterValidation("redirects", func(fl validator.FieldLevel) bool {
+		v := fl.Field().String()
+		n, err := strconv.Atoi(v)
+		return v == "follow" || v == "none" || (err == nil && n >= 0)
+	})
 	return validate.Struct(cfg)
 }
 
@@ -158,21 +864,47 @@ func (c *Config) CreateValidatorMessage(e validator.FieldError) string {
 		return fmt.Sprintf("must be one of [%s]", e.Param())
 	case "gte":
 		return fmt.Sprintf("must be greater than or equal to %s", e.Param())
+	case "method":
+		return "must be an uppercase HTTP method, e.g. GET or PURGE"
+	case "baseurl":
+		return "must be a valid URL or http+unix:///path/to.sock"
+	case "redirects":
+		return "must be follow, none or a number of redirects"
 	default:
 		return fmt.Sprintf("failed validation rule '%s'", e.Tag())
 	}
 }
 
 // Parameter
+
+// ErrNoValue is returned by GetValue when a parameter has no flag, mode or default value
+var ErrNoValue = errors.New("no value provided")
+
 func (p Parameter) GetValue(flags map[string]any, key string, e Endpoint) (any, error) {
-	// Get the default value
 	defaultVal, hasDefaultValue := e.Defaults[key]
+	return p.value(flags, key, defaultVal, hasDefaultValue)
+}
+
+// value resolves the parameter at key, a dotted path such as address.city
+// for nested fields, from its flag, mode or default value
+func (p Parameter) value(flags map[string]any, key string, defaultVal any, hasDefaultValue bool) (any, error) {
+	if p.Type == "object" || p.Type == "array" {
+		return p.nestedValue(flags, key, defaultVal, hasDefaultValue)
+	}
 
 	// Check for flag value
 	flagVal, ok := flags[key]
 	if ok {
 		return flagVal, nil
 	}
+	// Header names are case insensitive, --idempotency-key sets Idempotency-Key
+	if p.In == "header" {
+		for name, val := range flags {
+			if strings.EqualFold(name, key) {
+				return val, nil
+			}
+		}
+	}
 
 	modeParts := strings.Split(p.Mode, ":")
 	if len(modeParts) == 2 {
@@ -181,7 +913,7 @@ func (p Parameter) GetValue(flags map[string]any, key string, e Endpoint) (any,
 
 		if modeType == "env" {
 			v, err := p.GetEnvValue(modeValue, defaultVal)
-			if err == nil && v != "" {
+			if err == nil && v != nil && v != "" {
 				return v, nil
 			}
 		}
@@ -198,7 +930,209 @@ func (p Parameter) GetValue(flags map[string]any, key string, e Endpoint) (any,
 		return defaultVal, nil
 	}
 
-	return nil, fmt.Errorf("no value provided for parameter: %s", key)
+	return nil, fmt.Errorf("%w for parameter: %s", ErrNoValue, key)
+}
+
+// nestedValue resolves an object or array. Its default is completed with
+// the properties and items modes, then the flag value (as JSON) is merged
+// over it and flags such as --address.city=Paris or --items.0.sku=X
+// override single fields
+func (p Parameter) nestedValue(flags map[string]any, key string, defaultVal any, hasDefaultValue bool) (any, error) {
+	val, hasVal := normalizeValue(defaultVal), hasDefaultValue
+
+	switch p.Type {
+	case "object":
+		obj, _ := val.(map[string]any)
+		if obj == nil {
+			obj = map[string]any{}
+		}
+		for _, name := range sortedNames(p.Properties) {
+			prop := p.Properties[name]
+			childDefault, hasChild := obj[name]
+			childVal, err := prop.value(nil, key+"."+name, childDefault, hasChild)
+			if err == nil {
+				obj[name] = childVal
+				hasVal = true
+			} else if prop.SendNull && errors.Is(err, ErrNoValue) {
+				obj[name] = nil
+			}
+		}
+		val = obj
+	case "array":
+		list, _ := val.([]any)
+		if list == nil && p.Items != nil && p.Rules.Count > 0 {
+			for i := 0; i < p.Rules.Count; i++ {
+				item, err := p.Items.value(nil, fmt.Sprintf("%s.%d", key, i), nil, false)
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, item)
+			}
+			hasVal = true
+		} else if p.Items != nil {
+			for i, item := range list {
+				if resolved, err := p.Items.value(nil, fmt.Sprintf("%s.%d", key, i), item, true); err == nil {
+					list[i] = resolved
+				}
+			}
+		}
+		val = list
+	}
+
+	if flagVal, ok := flags[key]; ok {
+		str, isString := flagVal.(string)
+		trimmed := strings.TrimSpace(str)
+		switch {
+		case !isString:
+		case strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "["):
+			if err := json.Unmarshal([]byte(trimmed), &flagVal); err != nil {
+				return nil, fmt.Errorf("invalid JSON: %w", err)
+			}
+		case p.Type == "array":
+			// A single flag, e.g. --tag a, is a one item list
+			flagVal = []any{str}
+		default:
+			return nil, fmt.Errorf("must be a JSON object")
+		}
+		val, hasVal = MergeValue(val, normalizeValue(flagVal)), true
+	}
+
+	// Flags of nested fields, in order so that items.0 is set before items.1
+	prefix := key + "."
+	nested := make([]string, 0)
+	for name := range flags {
+		if strings.HasPrefix(name, prefix) {
+			nested = append(nested, name)
+		}
+	}
+	sort.Strings(nested)
+	for _, name := range nested {
+		val = setPath(val, strings.Split(strings.TrimPrefix(name, prefix), "."), flags[name])
+		hasVal = true
+	}
+
+	if !hasVal {
+		return nil, fmt.Errorf("%w for parameter: %s", ErrNoValue, key)
+	}
+	if err := p.checkRequired(key, val); err != nil {
+		return nil, err
+	}
+	return val, nil
+}
+
+// checkRequired reports the first required field missing from an object or its items
+func (p Parameter) checkRequired(key string, val any) error {
+	switch v := val.(type) {
+	case map[string]any:
+		for _, name := range sortedNames(p.Properties) {
+			prop := p.Properties[name]
+			child, ok := v[name]
+			if !ok {
+				if prop.Required {
+					return fmt.Errorf("missing required field %s.%s", key, name)
+				}
+				continue
+			}
+			if err := prop.checkRequired(key+"."+name, child); err != nil {
+				return err
+			}
+		}
+	case []any:
+		if p.Items == nil {
+			return nil
+		}
+		for i, item := range v {
+			if err := p.Items.checkRequired(fmt.Sprintf("%s.%d", key, i), item); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// setPath sets the field at path in an object or array, creating the
+// intermediate ones. Numeric segments index arrays
+func setPath(target any, path []string, val any) any {
+	if len(path) == 0 {
+		return val
+	}
+
+	if i, err := strconv.Atoi(path[0]); err == nil && i >= 0 {
+		list, _ := target.([]any)
+		for len(list) <= i {
+			list = append(list, nil)
+		}
+		list[i] = setPath(list[i], path[1:], val)
+		return list
+	}
+
+	obj, _ := target.(map[string]any)
+	if obj == nil {
+		obj = map[string]any{}
+	}
+	obj[path[0]] = setPath(obj[path[0]], path[1:], val)
+	return obj
+}
+
+// MergeValue deep merges the fields of override into base objects, any other value replaces base
+func MergeValue(base, override any) any {
+	baseObj, baseIsObj := base.(map[string]any)
+	overrideObj, overrideIsObj := override.(map[string]any)
+	if !baseIsObj || !overrideIsObj {
+		return override
+	}
+	for k, v := range overrideObj {
+		baseObj[k] = MergeValue(baseObj[k], v)
+	}
+	return baseObj
+}
+
+// normalizeValue deep copies a value, turning the map[any]any of YAML into
+// map[string]any so nested values can be changed without touching the config
+func normalizeValue(v any) any {
+	switch val := v.(type) {
+	case map[any]any:
+		out := make(map[string]any, len(val))
+		for k, item := range val {
+			out[fmt.Sprintf("%v", k)] = normalizeValue(item)
+		}
+		return out
+	case map[string]any:
+		out := make(map[string]any, len(val))
+		for k, item := range val {
+			out[k] = normalizeValue(item)
+		}
+		return out
+	case []any:
+		out := make([]any, len(val))
+		for i, item := range val {
+			out[i] = normalizeValue(item)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+func sortedNames[T any](m map[string]T) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Location returns where the parameter is sent. Without an explicit `in`,
+// parameters go in the body of endpoints that have one and in the query otherwise
+func (p Parameter) Location(e Endpoint) string {
+	if p.In != "" {
+		return p.In
+	}
+	if e.HasBody() {
+		return "body"
+	}
+	return "query"
 }
 
 // ENV
@@ -264,7 +1198,14 @@ func (FakerLoremIpsumParam) Get(p Parameter) (any, error) {
 	return gofakeit.LoremIpsumWord(), nil
 }
 func (FakerNumberParam) Get(p Parameter) (any, error) {
-	return gofakeit.Number(p.Rules.Min, p.Rules.Max), nil
+	var minimum, maximum int
+	if p.Rules.Min != nil {
+		minimum = *p.Rules.Min
+	}
+	if p.Rules.Max != nil {
+		maximum = *p.Rules.Max
+	}
+	return gofakeit.Number(minimum, maximum), nil
 }
 func (FakerImageParam) Get(p Parameter) (any, error) {
 	return gofakeit.Image(p.Rules.Width, p.Rules.Height), nil
@@ -275,6 +1216,18 @@ func (FakerSentenceParam) Get(p Parameter) (any, error) {
 func (FakerParagraphParam) Get(p Parameter) (any, error) {
 	return gofakeit.Paragraph(p.Rules.ParagraphCount, p.Rules.SentenceCount, p.Rules.WordCount, "\n"), nil
 }
+func (FakerUUIDParam) Get(p Parameter) (any, error) {
+	return gofakeit.UUID(), nil
+}
+func (FakerURLParam) Get(p Parameter) (any, error) {
+	return gofakeit.URL(), nil
+}
+func (FakerDateParam) Get(p Parameter) (any, error) {
+	return gofakeit.Date().Format(time.DateOnly), nil
+}
+func (FakerDateTimeParam) Get(p Parameter) (any, error) {
+	return gofakeit.Date().Format(time.RFC3339), nil
+}
 
 func (p Parameter) GetFakerValue(key string) (any, error) {
 	getter, ok := fakerParamTypeRegistry[key]
@@ -0,0 +1,230 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"net/mail"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Violation is a value breaking the type or the rules of a parameter
+type Violation struct {
+	// Parameter name, or path for nested fields, e.g. address.city
+	Field   string
+	Message string
+}
+
+var uuidRe = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// Coerce converts a resolved value to the parameter type, down to the nested
+// fields of objects and arrays, and reports every rule it breaks. Values that
+// cannot be converted are returned unchanged along with a violation
+func (p Parameter) Coerce(key string, val any) (any, []Violation) {
+	if val == nil {
+		if !p.Nullable && !p.SendNull {
+			return nil, []Violation{{Field: key, Message: "is not nullable"}}
+		}
+		return nil, nil
+	}
+
+	converted, err := p.convert(val)
+	if err != nil {
+		return val, []Violation{{Field: key, Message: err.Error()}}
+	}
+
+	var violations []Violation
+	for _, msg := range p.check(converted) {
+		violations = append(violations, Violation{Field: key, Message: msg})
+	}
+
+	switch v := converted.(type) {
+	case map[string]any:
+		for _, name := range sortedNames(p.Properties) {
+			child, ok := v[name]
+			if !ok {
+				continue
+			}
+			childVal, childViolations := p.Properties[name].Coerce(key+"."+name, child)
+			v[name] = childVal
+			violations = append(violations, childViolations...)
+		}
+	case []any:
+		if p.Items != nil {
+			for i, item := range v {
+				itemVal, itemViolations := p.Items.Coerce(fmt.Sprintf("%s.%d", key, i), item)
+				v[i] = itemVal
+				violations = append(violations, itemViolations...)
+			}
+		}
+	}
+
+	return converted, violations
+}
+
+// convert converts a value to the parameter type
+func (p Parameter) convert(val any) (any, error) {
+	switch p.Type {
+	case "string":
+		switch v := val.(type) {
+		case string:
+			return v, nil
+		case map[string]any, map[any]any, []any:
+			return nil, fmt.Errorf("must be a string")
+		default:
+			return fmt.Sprintf("%v", v), nil
+		}
+	case "int":
+		switch v := val.(type) {
+		case int:
+			return v, nil
+		case int64:
+			return int(v), nil
+		case float64:
+			if v == math.Trunc(v) {
+				return int(v), nil
+			}
+		case string:
+			if i, err := strconv.Atoi(strings.TrimSpace(v)); err == nil {
+				return i, nil
+			}
+		}
+		return nil, fmt.Errorf("must be an int, got %v", val)
+	case "float":
+		switch v := val.(type) {
+		case float64:
+			return v, nil
+		case int:
+			return float64(v), nil
+		case int64:
+			return float64(v), nil
+		case string:
+			if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+				return f, nil
+			}
+		}
+		return nil, fmt.Errorf("must be a float, got %v", val)
+	case "bool":
+		switch v := val.(type) {
+		case bool:
+			return v, nil
+		case string:
+			if b, err := strconv.ParseBool(strings.TrimSpace(v)); err == nil {
+				return b, nil
+			}
+		}
+		return nil, fmt.Errorf("must be a bool, got %v", val)
+	case "file":
+		// A path, optionally prefixed with @ like curl
+		path, ok := val.(string)
+		if !ok {
+			return nil, fmt.Errorf("must be a file path")
+		}
+		path = strings.TrimPrefix(path, "@")
+		if info, err := os.Stat(path); err != nil || info.IsDir() {
+			return nil, fmt.Errorf("file %s not found", path)
+		}
+		return path, nil
+	case "object":
+		switch v := normalizeValue(val).(type) {
+		case map[string]any:
+			return v, nil
+		}
+		return nil, fmt.Errorf("must be an object")
+	case "array":
+		switch v := normalizeValue(val).(type) {
+		case []any:
+			return v, nil
+		case map[string]any:
+			return nil, fmt.Errorf("must be an array")
+		default:
+			// A single flag, e.g. --tag a, is a one item list
+			return []any{v}, nil
+		}
+	default:
+		return val, nil
+	}
+}
+
+// check returns the rules a converted value breaks
+func (p Parameter) check(val any) []string {
+	r := p.Rules
+	var msgs []string
+
+	switch v := val.(type) {
+	case int:
+		msgs = append(msgs, r.checkRange(float64(v))...)
+	case float64:
+		msgs = append(msgs, r.checkRange(v)...)
+	case string:
+		length := utf8.RuneCountInString(v)
+		if r.MinLength != 0 && length < r.MinLength {
+			msgs = append(msgs, fmt.Sprintf("must be at least %d characters", r.MinLength))
+		}
+		if r.MaxLength != 0 && length > r.MaxLength {
+			msgs = append(msgs, fmt.Sprintf("must be at most %d characters", r.MaxLength))
+		}
+		if r.Pattern != "" {
+			re, err := regexp.Compile(r.Pattern)
+			if err != nil {
+				msgs = append(msgs, fmt.Sprintf("invalid pattern %s: %s", r.Pattern, err))
+			} else if !re.MatchString(v) {
+				msgs = append(msgs, fmt.Sprintf("must match %s", r.Pattern))
+			}
+		}
+		if r.Format != "" && !validFormat(r.Format, v) {
+			msgs = append(msgs, fmt.Sprintf("must be a valid %s", r.Format))
+		}
+	}
+
+	if len(r.Enum) > 0 {
+		valid := false
+		options := make([]string, len(r.Enum))
+		for i, option := range r.Enum {
+			options[i] = fmt.Sprintf("%v", option)
+			valid = valid || options[i] == fmt.Sprintf("%v", val)
+		}
+		if !valid {
+			msgs = append(msgs, fmt.Sprintf("must be one of [%s]", strings.Join(options, ", ")))
+		}
+	}
+
+	return msgs
+}
+
+func (r Rules) checkRange(n float64) []string {
+	var msgs []string
+	if r.Min != nil && n < float64(*r.Min) {
+		msgs = append(msgs, fmt.Sprintf("must be greater than or equal to %d", *r.Min))
+	}
+	if r.Max != nil && n > float64(*r.Max) {
+		msgs = append(msgs, fmt.Sprintf("must be less than or equal to %d", *r.Max))
+	}
+	return msgs
+}
+
+func validFormat(format, v string) bool {
+	switch format {
+	case "uuid":
+		return uuidRe.MatchString(v)
+	case "email":
+		addr, err := mail.ParseAddress(v)
+		return err == nil && addr.Address == v
+	case "date":
+		_, err := time.Parse(time.DateOnly, v)
+		return err == nil
+	case "date-time":
+		_, err := time.Parse(time.RFC3339, v)
+		return err == nil
+	case "url":
+		u, err := url.ParseRequestURI(v)
+		return err == nil && u.Scheme != "" && u.Host != ""
+	default:
+		return true
+	}
+}
@@ -0,0 +1,143 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// MergeSection adds entries under a top-level section (endpoints, environments...)
+// of a config file. The file is edited as text so comments and hand edits
+// survive, and entries whose name already exists are left untouched and
+// returned as skipped.
+func MergeSection(data []byte, section string, entries yaml.MapSlice) ([]byte, []string, error) {
+	var doc yaml.MapSlice
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, nil, fmt.Errorf("error unmarshaling config file: %w", err)
+	}
+
+	existing := map[string]bool{}
+	for _, item := range doc {
+		if fmt.Sprintf("%v", item.Key) != section {
+			continue
+		}
+		if block, ok := item.Value.(yaml.MapSlice); ok {
+			for _, e := range block {
+				existing[fmt.Sprintf("%v", e.Key)] = true
+			}
+		}
+	}
+
+	var added yaml.MapSlice
+	var skipped []string
+	for _, e := range entries {
+		name := fmt.Sprintf("%v", e.Key)
+		if existing[name] {
+			skipped = append(skipped, name)
+			continue
+		}
+		existing[name] = true
+		added = append(added, e)
+	}
+	if len(added) == 0 {
+		return data, skipped, nil
+	}
+
+	rendered, err := yaml.Marshal(added)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error marshaling %s: %w", section, err)
+	}
+
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	if len(data) == 0 {
+		lines = nil
+	}
+
+	start := -1
+	for i, l := range lines {
+		if !strings.HasPrefix(l, section+":") {
+			continue
+		}
+		if !isInlineEmpty(strings.TrimPrefix(l, section+":")) {
+			return nil, nil, fmt.Errorf("cannot merge into the inline %s section, write it as a block", section)
+		}
+		start = i
+		break
+	}
+
+	// No section yet, add it at the end of the file
+	if start == -1 {
+		lines = append(lines, section+":")
+		lines = append(lines, indentLines(rendered, "  ")...)
+		return []byte(strings.Join(lines, "\n") + "\n"), skipped, nil
+	}
+	lines[start] = section + ":"
+
+	// Find where the section ends and how its entries are indented
+	indent := "  "
+	end := len(lines)
+	foundIndent := false
+	for i := start + 1; i < len(lines); i++ {
+		l := lines[i]
+		trimmed := strings.TrimSpace(l)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if l[0] != ' ' && l[0] != '\t' {
+			end = i
+			break
+		}
+		if !foundIndent {
+			indent = l[:len(l)-len(strings.TrimLeft(l, " \t"))]
+			foundIndent = true
+		}
+	}
+
+	// Keep blank lines and comments introducing the next section attached to it
+	insertAt := end
+	for insertAt > start+1 {
+		l := lines[insertAt-1]
+		if strings.TrimSpace(l) != "" && !strings.HasPrefix(l, "#") {
+			break
+		}
+		insertAt--
+	}
+
+	out := make([]string, 0, len(lines)+len(added)*8)
+	out = append(out, lines[:insertAt]...)
+	out = append(out, indentLines(rendered, indent)...)
+	out = append(out, lines[insertAt:]...)
+	return []byte(strings.Join(out, "\n") + "\n"), skipped, nil
+}
+
+// WriteFile writes a config file keeping the permissions of an existing one
+func WriteFile(path string, data []byte) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if err := os.WriteFile(path, data, mode); err != nil {
+		return fmt.Errorf("error writing %s: %w", path, err)
+	}
+	return nil
+}
+
+func isInlineEmpty(rest string) bool {
+	rest = strings.TrimSpace(rest)
+	if i := strings.Index(rest, "#"); i >= 0 {
+		rest = strings.TrimSpace(rest[:i])
+	}
+	return rest == "" || rest == "{}" || rest == "~" || rest == "null"
+}
+
+func indentLines(data []byte, indent string) []string {
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	for i, l := range lines {
+		if l != "" {
+			lines[i] = indent + l
+		}
+	}
+	return lines
+}
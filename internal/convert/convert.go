@@ -0,0 +1,303 @@
+package convert
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/killuox/koi/internal/config"
+	"gopkg.in/yaml.v2"
+)
+
+// Collection is the result of an import, ready to be merged into a config file
+type Collection struct {
+	BaseURL      string
+	Headers      map[string]string
+	Auth         config.Auth
+	Endpoints    []NamedEndpoint
+	Environments map[string]config.Environment
+	Variables    map[string]any
+	Warnings     []string
+}
+
+type NamedEndpoint struct {
+	Name     string
+	Endpoint config.Endpoint
+}
+
+// AddEndpoint adds an endpoint under a unique name derived from name
+func (c *Collection) AddEndpoint(name string, e config.Endpoint) {
+	name = EndpointName(name)
+	if name == "" {
+		name = EndpointName(e.Method + " " + e.Path)
+	}
+
+	unique := name
+	for i := 2; c.hasEndpoint(unique); i++ {
+		unique = fmt.Sprintf("%s-%d", name, i)
+	}
+	c.Endpoints = append(c.Endpoints, NamedEndpoint{Name: unique, Endpoint: e})
+}
+
+func (c *Collection) Warn(format string, args ...any) {
+	c.Warnings = append(c.Warnings, fmt.Sprintf(format, args...))
+}
+
+func (c *Collection) hasEndpoint(name string) bool {
+	for _, e := range c.Endpoints {
+		if e.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// MergeInto adds the collection to the content of a config file. Existing
+// endpoints, environments and variables are never overwritten, their names
+// are returned as skipped instead
+func (c *Collection) MergeInto(data []byte) ([]byte, []string, error) {
+	if len(strings.TrimSpace(string(data))) == 0 {
+		baseURL := c.BaseURL
+		if baseURL == "" {
+			baseURL = "http://localhost:8080"
+		}
+		api := config.API{BaseURL: baseURL, Headers: c.Headers, Auth: c.Auth}
+		header, err := yaml.Marshal(yaml.MapSlice{{Key: "api", Value: api}})
+		if err != nil {
+			return nil, nil, err
+		}
+		data = header
+	} else {
+		for _, name := range sortedKeys(c.Headers) {
+			c.Warn("api header %s: %s was not merged into the existing api section", name, c.Headers[name])
+		}
+		if c.Auth.Type != "" {
+			c.Warn("api %s auth was not merged into the existing api section", c.Auth.Type)
+		}
+	}
+
+	var skipped []string
+	sections := []struct {
+		name    string
+		entries yaml.MapSlice
+	}{
+		{"variables", sortedEntries(c.Variables)},
+		{"environments", sortedEntries(c.Environments)},
+		{"endpoints", c.endpointEntries()},
+	}
+	for _, section := range sections {
+		if len(section.entries) == 0 {
+			continue
+		}
+		merged, sectionSkipped, err := config.MergeSection(data, section.name, section.entries)
+		if err != nil {
+			return nil, nil, err
+		}
+		data = merged
+		for _, name := range sectionSkipped {
+			skipped = append(skipped, section.name+"."+name)
+		}
+	}
+
+	return data, skipped, nil
+}
+
+func (c *Collection) endpointEntries() yaml.MapSlice {
+	entries := make(yaml.MapSlice, 0, len(c.Endpoints))
+	for _, e := range c.Endpoints {
+		entries = append(entries, yaml.MapItem{Key: e.Name, Value: e.Endpoint})
+	}
+	return entries
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedEntries[T any](m map[string]T) yaml.MapSlice {
+	entries := make(yaml.MapSlice, 0, len(m))
+	for _, k := range sortedKeys(m) {
+		entries = append(entries, yaml.MapItem{Key: k, Value: m[k]})
+	}
+	return entries
+}
+
+// EndpointName turns an operation id, a request name or a path into a
+// kebab-case endpoint name, e.g. listUsers or "List users" into list-users
+func EndpointName(s string) string {
+	var sb strings.Builder
+	prevLower := false
+	for _, r := range s {
+		switch {
+		case unicode.IsUpper(r):
+			if prevLower {
+				sb.WriteByte('-')
+			}
+			sb.WriteRune(unicode.ToLower(r))
+			prevLower = false
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			sb.WriteRune(r)
+			prevLower = true
+		default:
+			if sb.Len() > 0 && !strings.HasSuffix(sb.String(), "-") {
+				sb.WriteByte('-')
+			}
+			prevLower = false
+		}
+	}
+	return strings.Trim(sb.String(), "-")
+}
+
+type queryPair struct {
+	key   string
+	value string
+}
+
+var (
+	pathVariableRe = regexp.MustCompile(`/:(\w+)`)
+	pathParamRe    = regexp.MustCompile(`\{(\w+)\}`)
+)
+
+// splitURL splits a request URL into its base URL, its path and its query.
+// The base is either a scheme and host or a leading {{variable}}, and :name
+// path variables become koi {name} placeholders
+func splitURL(raw string) (base, path string, query []queryPair) {
+	raw = strings.TrimSpace(raw)
+	if i := strings.Index(raw, "#"); i >= 0 {
+		raw = raw[:i]
+	}
+	if i := strings.Index(raw, "?"); i >= 0 {
+		for _, pair := range strings.Split(raw[i+1:], "&") {
+			if pair == "" {
+				continue
+			}
+			key, value, _ := strings.Cut(pair, "=")
+			if unescaped, err := url.QueryUnescape(key); err == nil {
+				key = unescaped
+			}
+			if unescaped, err := url.QueryUnescape(value); err == nil {
+				value = unescaped
+			}
+			query = append(query, queryPair{key: key, value: value})
+		}
+		raw = raw[:i]
+	}
+
+	switch {
+	case strings.Contains(raw, "://"):
+		hostStart := strings.Index(raw, "://") + 3
+		if i := strings.Index(raw[hostStart:], "/"); i >= 0 {
+			base, path = raw[:hostStart+i], raw[hostStart+i:]
+		} else {
+			base = raw
+		}
+	case strings.HasPrefix(raw, "{{"):
+		if i := strings.Index(raw, "}}"); i >= 0 {
+			base, path = raw[:i+2], raw[i+2:]
+		} else {
+			path = raw
+		}
+	default:
+		path = raw
+	}
+
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	path = pathVariableRe.ReplaceAllString(path, "/{$1}")
+	return base, path, query
+}
+
+// sampleParameter infers the parameter of a sample value, with the
+// properties of objects and the items of arrays
+func sampleParameter(v any) config.Parameter {
+	switch val := v.(type) {
+	case bool:
+		return config.Parameter{Type: "bool"}
+	case int, int64:
+		return config.Parameter{Type: "int"}
+	case float64:
+		if val == float64(int64(val)) {
+			return config.Parameter{Type: "int"}
+		}
+		return config.Parameter{Type: "float"}
+	case map[string]any:
+		p := config.Parameter{Type: "object", Properties: map[string]config.Parameter{}}
+		for key, item := range val {
+			p.Properties[key] = sampleParameter(item)
+		}
+		return p
+	case []any:
+		p := config.Parameter{Type: "array"}
+		if len(val) > 0 {
+			item := sampleParameter(val[0])
+			p.Items = &item
+		}
+		return p
+	default:
+		return config.Parameter{Type: "string"}
+	}
+}
+
+// addBodyFromJSON turns the top-level fields of a sample JSON body into body
+// parameters, the sample values becoming defaults
+func (c *Collection) addBodyFromJSON(e *config.Endpoint, raw string) {
+	var body map[string]any
+	if err := json.Unmarshal([]byte(raw), &body); err != nil {
+		c.Warn("%s %s: skipped a body that is not a JSON object", e.Method, e.Path)
+		return
+	}
+	for _, key := range sortedKeys(body) {
+		p := sampleParameter(body[key])
+		p.In = "body"
+		setParameter(e, key, p, body[key])
+	}
+}
+
+// addRawBody adds a sample body of any type. JSON objects become body
+// parameters, other bodies are sent as is from a single body parameter
+func (c *Collection) addRawBody(e *config.Endpoint, raw, contentType string) {
+	trimmed := strings.TrimSpace(raw)
+	if strings.HasPrefix(trimmed, "{") {
+		c.addBodyFromJSON(e, raw)
+		return
+	}
+
+	e.BodyType = "raw"
+	if strings.Contains(contentType, "xml") || strings.HasPrefix(trimmed, "<") {
+		e.BodyType = "xml"
+	}
+	setParameter(e, "body", config.Parameter{Type: "string", In: "body"}, raw)
+}
+
+// setHeader adds a static endpoint header
+func setHeader(e *config.Endpoint, name, value string) {
+	if e.Headers == nil {
+		e.Headers = map[string]string{}
+	}
+	e.Headers[name] = value
+}
+
+// setParameter adds a parameter along with its default value, if any
+func setParameter(e *config.Endpoint, name string, p config.Parameter, defaultVal any) {
+	if e.Parameters == nil {
+		e.Parameters = map[string]config.Parameter{}
+	}
+	e.Parameters[name] = p
+	if defaultVal == nil || defaultVal == "" {
+		return
+	}
+	if e.Defaults == nil {
+		e.Defaults = map[string]any{}
+	}
+	e.Defaults[name] = defaultVal
+}
@@ -0,0 +1,304 @@
+package convert
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/killuox/koi/internal/config"
+)
+
+// curl options that take a value but don't matter to the endpoint
+var curlIgnoredWithValue = map[string]bool{
+	"-o": true, "--output": true, "-m": true, "--max-time": true, "--connect-timeout": true,
+	"-A": true, "--user-agent": true, "-e": true, "--referer": true, "-w": true, "--write-out": true,
+	"--retry": true, "--cacert": true, "--cert": true, "--key": true, "-x": true, "--proxy": true,
+	"--resolve": true,
+}
+
+// curl options without a value that don't matter to the endpoint
+var curlIgnored = map[string]bool{
+	"-s": true, "--silent": true, "-S": true, "--show-error": true, "-v": true, "--verbose": true,
+	"-L": true, "--location": true, "-k": true, "--insecure": true, "-i": true, "--include": true,
+	"--compressed": true, "-f": true, "--fail": true, "-g": true, "--globoff": true,
+}
+
+// ImportCurl converts a curl command line into an endpoint
+func ImportCurl(command string) (*Collection, error) {
+	args, err := shellSplit(command)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) > 0 && (args[0] == "curl" || strings.HasSuffix(args[0], "/curl")) {
+		args = args[1:]
+	}
+
+	c := &Collection{}
+	headers := map[string]string{}
+	var rawURL, method string
+	var data []string
+	var form []string
+	var getData, jsonData bool
+	var auth config.Auth
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		name, inline, hasInline := strings.Cut(arg, "=")
+		if !strings.HasPrefix(arg, "--") || !hasInline {
+			name, inline, hasInline = arg, "", false
+		}
+
+		// Short options can be glued to their value, e.g. -XPOST
+		if len(name) > 2 && name[0] == '-' && name[1] != '-' && strings.ContainsRune("XHdFub", rune(name[1])) {
+			name, inline, hasInline = name[:2], name[2:], true
+		}
+
+		value := func() (string, error) {
+			if hasInline {
+				return inline, nil
+			}
+			if i+1 >= len(args) {
+				return "", fmt.Errorf("curl option %s expects a value", name)
+			}
+			i++
+			return args[i], nil
+		}
+
+		switch {
+		case !strings.HasPrefix(name, "-"):
+			rawURL = arg
+		case name == "-X" || name == "--request":
+			if method, err = value(); err != nil {
+				return nil, err
+			}
+			method = strings.ToUpper(method)
+		case name == "--url":
+			if rawURL, err = value(); err != nil {
+				return nil, err
+			}
+		case name == "-H" || name == "--header":
+			header, err := value()
+			if err != nil {
+				return nil, err
+			}
+			key, val, _ := strings.Cut(header, ":")
+			headers[strings.TrimSpace(key)] = strings.TrimSpace(val)
+		case name == "-d" || name == "--data" || name == "--data-raw" || name == "--data-binary" ||
+			name == "--data-ascii" || name == "--data-urlencode" || name == "--json":
+			d, err := value()
+			if err != nil {
+				return nil, err
+			}
+			if strings.HasPrefix(d, "@") && name != "--data-raw" {
+				c.Warn("skipped body read from file %s", d[1:])
+				continue
+			}
+			data = append(data, d)
+			jsonData = jsonData || name == "--json"
+		case name == "-F" || name == "--form" || name == "--form-string":
+			f, err := value()
+			if err != nil {
+				return nil, err
+			}
+			form = append(form, f)
+		case name == "-u" || name == "--user":
+			credentials, err := value()
+			if err != nil {
+				return nil, err
+			}
+			username, password, _ := strings.Cut(credentials, ":")
+			auth.Username, auth.Password = username, password
+			if auth.Type == "" {
+				auth.Type = "basic"
+			}
+		case name == "--basic":
+			auth.Type = "basic"
+		case name == "--digest":
+			auth.Type = "digest"
+		case name == "--oauth2-bearer":
+			if auth.Token, err = value(); err != nil {
+				return nil, err
+			}
+			auth.Type = "bearer"
+		case name == "--aws-sigv4":
+			// provider1[:provider2[:region[:service]]]
+			providers, err := value()
+			if err != nil {
+				return nil, err
+			}
+			parts := strings.SplitN(providers, ":", 4)
+			auth.Type = "aws-sigv4"
+			if len(parts) > 2 {
+				auth.Region = parts[2]
+			}
+			if len(parts) > 3 {
+				auth.Service = parts[3]
+			}
+		case name == "-G" || name == "--get":
+			getData = true
+		case name == "-I" || name == "--head":
+			method = "HEAD"
+		case name == "-b" || name == "--cookie":
+			cookie, err := value()
+			if err != nil {
+				return nil, err
+			}
+			headers["Cookie"] = cookie
+		case curlIgnored[name]:
+		case curlIgnoredWithValue[name]:
+			if _, err := value(); err != nil {
+				return nil, err
+			}
+		default:
+			c.Warn("curl option %s is not supported", name)
+		}
+	}
+
+	if rawURL == "" {
+		return nil, fmt.Errorf("no URL found in the curl command")
+	}
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "http://" + rawURL
+	}
+	if method == "" {
+		method = "GET"
+		if (len(data) > 0 && !getData) || len(form) > 0 {
+			method = "POST"
+		}
+	}
+
+	// -u holds the access key and secret with --aws-sigv4
+	if auth.Type == "aws-sigv4" {
+		auth.AccessKeyID, auth.SecretAccessKey, auth.Username, auth.Password = auth.Username, auth.Password, "", ""
+		if auth.Region == "" || auth.Service == "" {
+			c.Warn("--aws-sigv4 without a region and service, set them in the endpoint auth")
+		}
+	}
+
+	base, path, query := splitURL(rawURL)
+	c.BaseURL = base
+	e := config.Endpoint{Method: method, Path: path, Auth: auth}
+
+	for _, q := range query {
+		setParameter(&e, q.key, config.Parameter{Type: "string", In: "query"}, q.value)
+	}
+
+	body := strings.Join(data, "&")
+	contentType := strings.ToLower(headers["Content-Type"])
+	switch {
+	case body == "":
+	case getData:
+		for _, pair := range parseForm(body) {
+			setParameter(&e, pair.key, config.Parameter{Type: "string", In: "query"}, pair.value)
+		}
+	case jsonData || strings.Contains(contentType, "json") || json.Valid([]byte(body)):
+		c.addBodyFromJSON(&e, body)
+		delete(headers, "Content-Type")
+	default:
+		for _, pair := range parseForm(body) {
+			setParameter(&e, pair.key, config.Parameter{Type: "string", In: "body"}, pair.value)
+		}
+		e.BodyType = "form"
+		delete(headers, "Content-Type")
+	}
+
+	for _, f := range form {
+		key, val, _ := strings.Cut(f, "=")
+		switch {
+		case strings.HasPrefix(val, "@"):
+			// Drop the ;type= and ;filename= options of the field
+			path, _, _ := strings.Cut(val, ";")
+			setParameter(&e, key, config.Parameter{Type: "file", In: "body"}, path)
+		case strings.HasPrefix(val, "<"):
+			c.Warn("skipped field %q read from a file", key)
+		default:
+			setParameter(&e, key, config.Parameter{Type: "string", In: "body"}, val)
+		}
+	}
+	if len(form) > 0 {
+		e.BodyType = "multipart"
+		delete(headers, "Content-Type")
+	}
+
+	for name, val := range headers {
+		setHeader(&e, name, val)
+	}
+
+	if !config.ValidMethod(method) {
+		c.Warn("method %s is not supported", method)
+		return c, nil
+	}
+	c.AddEndpoint("", e)
+	return c, nil
+}
+
+func parseForm(body string) []queryPair {
+	var pairs []queryPair
+	for _, pair := range strings.Split(body, "&") {
+		key, val, _ := strings.Cut(pair, "=")
+		if unescaped, err := url.QueryUnescape(val); err == nil {
+			val = unescaped
+		}
+		if key != "" {
+			pairs = append(pairs, queryPair{key: key, value: val})
+		}
+	}
+	return pairs
+}
+
+// shellSplit splits a command line the way a POSIX shell would, handling
+// quotes, escapes and line continuations
+func shellSplit(s string) ([]string, error) {
+	var args []string
+	var cur strings.Builder
+	inArg := false
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s) && (s[i+1] == '\n' || s[i+1] == '\r'):
+			i++
+			if s[i] == '\r' && i+1 < len(s) && s[i+1] == '\n' {
+				i++
+			}
+		case c == '\\' && i+1 < len(s):
+			i++
+			cur.WriteByte(s[i])
+			inArg = true
+		case c == '\'':
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated single quote")
+			}
+			cur.WriteString(s[i+1 : i+1+end])
+			i += end + 1
+			inArg = true
+		case c == '"':
+			i++
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) && strings.IndexByte("\\\"$`\n", s[i+1]) >= 0 {
+					i++
+				}
+				cur.WriteByte(s[i])
+			}
+			if i >= len(s) {
+				return nil, fmt.Errorf("unterminated double quote")
+			}
+			inArg = true
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			if inArg {
+				args = append(args, cur.String())
+				cur.Reset()
+				inArg = false
+			}
+		default:
+			cur.WriteByte(c)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, cur.String())
+	}
+	return args, nil
+}
@@ -0,0 +1,90 @@
+package convert
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/killuox/koi/internal/config"
+	"github.com/killuox/koi/internal/httpfile"
+)
+
+var httpResponseRefRe = regexp.MustCompile(`\{\{\s*[\w-]+\.response\.[^}]*\}\}`)
+
+// ImportHTTPFile converts the requests of a .http file into endpoints and its
+// file variables into static variables
+func ImportHTTPFile(data []byte) (*Collection, error) {
+	file, err := httpfile.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Collection{Variables: map[string]any{}}
+	for _, v := range file.Variables {
+		c.Variables[v.Name] = httpfile.TranslateSystemVariables(v.Value)
+	}
+
+	for _, req := range file.Requests {
+		name := req.DisplayName()
+		text := func(s string) string {
+			s = httpfile.TranslateSystemVariables(s)
+			if httpResponseRefRe.MatchString(s) {
+				c.Warn("%s: response references are not supported, use set-variables instead", name)
+			}
+			return s
+		}
+
+		base, path, query := splitURL(text(req.URL))
+		if c.BaseURL == "" {
+			c.BaseURL = base
+		}
+
+		e := config.Endpoint{Method: req.Method, Path: path, Description: req.Title}
+		for _, match := range pathParamRe.FindAllStringSubmatch(path, -1) {
+			setParameter(&e, match[1], config.Parameter{Type: "string", In: "path", Required: true}, nil)
+		}
+		for _, q := range query {
+			setParameter(&e, q.key, config.Parameter{Type: "string", In: "query"}, text(q.value))
+		}
+
+		contentType := ""
+		for _, h := range req.Headers {
+			if strings.EqualFold(h.Name, "Content-Type") {
+				contentType = strings.ToLower(h.Value)
+				continue
+			}
+			setHeader(&e, h.Name, text(h.Value))
+		}
+
+		if req.Body != "" {
+			switch {
+			case strings.HasPrefix(req.Body, "< ") || strings.HasPrefix(req.Body, "<@ "):
+				// < ./payload.json sends the content of a file
+				e.BodyType = "raw"
+				if strings.Contains(contentType, "xml") {
+					e.BodyType = "xml"
+				}
+				path := strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(req.Body, "<@"), "<"))
+				setParameter(&e, "body", config.Parameter{Type: "file", In: "body"}, path)
+			case strings.Contains(contentType, "x-www-form-urlencoded"):
+				for _, pair := range parseForm(text(req.Body)) {
+					setParameter(&e, pair.key, config.Parameter{Type: "string", In: "body"}, pair.value)
+				}
+				e.BodyType = "form"
+			case strings.Contains(contentType, "multipart"):
+				c.Warn("%s: skipped multipart body, describe its fields with body-type multipart", name)
+			case contentType == "" || strings.Contains(contentType, "json"):
+				c.addBodyFromJSON(&e, text(req.Body))
+			default:
+				c.addRawBody(&e, text(req.Body), contentType)
+			}
+		}
+
+		if !config.ValidMethod(e.Method) {
+			c.Warn("%s: method %s is not supported", name, e.Method)
+			continue
+		}
+		c.AddEndpoint(firstNonEmpty(req.Name, req.Title), e)
+	}
+
+	return c, nil
+}
@@ -0,0 +1,245 @@
+package convert
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/killuox/koi/internal/config"
+)
+
+type insomniaExport struct {
+	Type      string             `json:"_type"`
+	Format    int                `json:"__export_format"`
+	Resources []insomniaResource `json:"resources"`
+}
+
+// insomniaResource is any resource of an export, told apart by its _type
+type insomniaResource struct {
+	ID             string         `json:"_id"`
+	ParentID       string         `json:"parentId"`
+	Type           string         `json:"_type"`
+	Name           string         `json:"name"`
+	Description    string         `json:"description"`
+	Method         string         `json:"method"`
+	URL            string         `json:"url"`
+	Body           insomniaBody   `json:"body"`
+	Headers        []insomniaPair `json:"headers"`
+	Parameters     []insomniaPair `json:"parameters"`
+	Authentication map[string]any `json:"authentication"`
+	Data           map[string]any `json:"data"`
+	Hook           string         `json:"preRequestScript"`
+}
+
+type insomniaBody struct {
+	MimeType string         `json:"mimeType"`
+	Text     string         `json:"text"`
+	Params   []insomniaPair `json:"params"`
+}
+
+type insomniaPair struct {
+	Name     string `json:"name"`
+	Value    string `json:"value"`
+	Type     string `json:"type"`
+	Disabled bool   `json:"disabled"`
+	FileName string `json:"fileName"`
+}
+
+var (
+	insomniaVarRe = regexp.MustCompile(`\{\{\s*_\.([\w.]+)\s*\}\}`)
+	insomniaTagRe = regexp.MustCompile(`\{%\s*(\w+)[^%]*%\}`)
+)
+
+// ImportInsomnia converts an Insomnia v4 export into endpoints, profiles and
+// variables. The base environment becomes variables and its sub environments profiles
+func ImportInsomnia(data []byte) (*Collection, error) {
+	export := insomniaExport{}
+	if err := json.Unmarshal(data, &export); err != nil {
+		return nil, fmt.Errorf("error parsing Insomnia export: %w", err)
+	}
+	if export.Type != "export" || export.Format < 4 {
+		return nil, fmt.Errorf("unsupported Insomnia export, use the v4 JSON format")
+	}
+
+	c := &Collection{Variables: map[string]any{}, Environments: map[string]config.Environment{}}
+
+	byID := map[string]*insomniaResource{}
+	for i := range export.Resources {
+		r := &export.Resources[i]
+		byID[r.ID] = r
+	}
+
+	for i := range export.Resources {
+		r := &export.Resources[i]
+		if r.Type != "environment" {
+			continue
+		}
+		if parent, ok := byID[r.ParentID]; ok && parent.Type == "environment" {
+			c.Environments[EndpointName(r.Name)] = config.Environment{Variables: c.insomniaData(r.Data)}
+			continue
+		}
+		for k, v := range c.insomniaData(r.Data) {
+			c.Variables[k] = v
+		}
+	}
+
+	for i := range export.Resources {
+		r := &export.Resources[i]
+		if r.Type != "request" {
+			continue
+		}
+
+		// Folders become a prefix of the endpoint name
+		var folders []string
+		for parent := byID[r.ParentID]; parent != nil && parent.Type == "request_group"; parent = byID[parent.ParentID] {
+			folders = append([]string{parent.Name}, folders...)
+		}
+		c.insomniaRequest(r, strings.Join(append(folders, r.Name), " "))
+	}
+
+	return c, nil
+}
+
+func (c *Collection) insomniaRequest(r *insomniaResource, name string) {
+	base, path, query := splitURL(c.insomniaText(r.URL))
+	if c.BaseURL == "" {
+		c.BaseURL = base
+	}
+
+	e := config.Endpoint{
+		Method:      strings.ToUpper(r.Method),
+		Path:        path,
+		Description: r.Description,
+	}
+	if e.Method == "" {
+		e.Method = "GET"
+	}
+
+	for _, match := range pathParamRe.FindAllStringSubmatch(path, -1) {
+		setParameter(&e, match[1], config.Parameter{Type: "string", In: "path", Required: true}, nil)
+	}
+	for _, q := range query {
+		setParameter(&e, q.key, config.Parameter{Type: "string", In: "query"}, c.insomniaText(q.value))
+	}
+	for _, p := range r.Parameters {
+		if !p.Disabled {
+			setParameter(&e, p.Name, config.Parameter{Type: "string", In: "query"}, c.insomniaText(p.Value))
+		}
+	}
+
+	for _, h := range r.Headers {
+		if !h.Disabled && !strings.EqualFold(h.Name, "Content-Type") {
+			setHeader(&e, h.Name, c.insomniaText(h.Value))
+		}
+	}
+	e.Auth = c.insomniaAuth(r.Authentication, name)
+	if r.Hook != "" {
+		c.Warn("%s: pre-request scripts are not supported", name)
+	}
+
+	switch {
+	case strings.Contains(r.Body.MimeType, "json"):
+		if strings.TrimSpace(r.Body.Text) != "" {
+			c.addBodyFromJSON(&e, c.insomniaText(r.Body.Text))
+		}
+	case r.Body.MimeType == "application/x-www-form-urlencoded" || r.Body.MimeType == "multipart/form-data":
+		e.BodyType = "form"
+		if r.Body.MimeType == "multipart/form-data" {
+			e.BodyType = "multipart"
+		}
+		for _, p := range r.Body.Params {
+			switch {
+			case p.Disabled:
+			case p.Type == "file":
+				setParameter(&e, p.Name, config.Parameter{Type: "file", In: "body"}, p.FileName)
+			default:
+				setParameter(&e, p.Name, config.Parameter{Type: "string", In: "body"}, c.insomniaText(p.Value))
+			}
+		}
+	case strings.TrimSpace(r.Body.Text) != "":
+		c.addRawBody(&e, c.insomniaText(r.Body.Text), r.Body.MimeType)
+	case r.Body.MimeType != "":
+		c.Warn("%s: skipped %s body", name, r.Body.MimeType)
+	}
+
+	c.AddEndpoint(name, e)
+}
+
+// insomniaData flattens environment data into variables
+func (c *Collection) insomniaData(data map[string]any) map[string]any {
+	vars := map[string]any{}
+	for k, v := range data {
+		if s, ok := v.(string); ok {
+			vars[k] = c.insomniaText(s)
+			continue
+		}
+		vars[k] = v
+	}
+	return vars
+}
+
+// insomniaText translates {{ _.name }} variables and template tags
+func (c *Collection) insomniaText(s string) string {
+	s = insomniaVarRe.ReplaceAllString(s, "{{$1}}")
+	return insomniaTagRe.ReplaceAllStringFunc(s, func(match string) string {
+		tag := insomniaTagRe.FindStringSubmatch(match)[1]
+		if tag == "uuid" {
+			return "{{uuid}}"
+		}
+		if tag == "now" {
+			return "{{now}}"
+		}
+		c.Warn("template tag %s is not supported", tag)
+		return match
+	})
+}
+
+// insomniaAuth maps the authentication of an Insomnia request to koi auth
+func (c *Collection) insomniaAuth(auth map[string]any, name string) config.Auth {
+	attr := func(key string) string {
+		val, _ := auth[key].(string)
+		return c.insomniaText(val)
+	}
+
+	switch authType := attr("type"); authType {
+	case "", "none":
+		return config.Auth{}
+	case "basic", "digest":
+		return config.Auth{Type: authType, Username: attr("username"), Password: attr("password")}
+	case "bearer":
+		if prefix := attr("prefix"); prefix != "" && !strings.EqualFold(prefix, "Bearer") {
+			c.Warn("%s: bearer prefix %s is not supported", name, prefix)
+		}
+		return config.Auth{Type: "bearer", Token: attr("token")}
+	case "apikey":
+		in := "header"
+		if attr("addTo") == "queryParams" {
+			in = "query"
+		}
+		return config.Auth{Type: "api-key", Name: attr("key"), Key: attr("value"), In: in}
+	case "oauth2":
+		grant := attr("grantType")
+		if grant != "client_credentials" && grant != "password" && grant != "authorization_code" {
+			c.Warn("%s: oauth2 %s grant is not supported", name, grant)
+			return config.Auth{}
+		}
+		clientAuth := ""
+		if inBody, _ := auth["credentialsInBody"].(bool); inBody {
+			clientAuth = "body"
+		}
+		return config.Auth{
+			Type: "oauth2", Grant: grant, TokenURL: attr("accessTokenUrl"), AuthURL: attr("authorizationUrl"), RedirectURL: attr("redirectUrl"),
+			ClientID: attr("clientId"), ClientSecret: attr("clientSecret"), ClientAuth: clientAuth, Scopes: strings.Fields(attr("scope")),
+			Username: attr("username"), Password: attr("password"),
+		}
+	case "iam":
+		return config.Auth{
+			Type: "aws-sigv4", Service: attr("service"), Region: attr("region"),
+			AccessKeyID: attr("accessKeyId"), SecretAccessKey: attr("secretAccessKey"), SessionToken: attr("sessionToken"),
+		}
+	default:
+		c.Warn("%s: %s auth is not supported", name, authType)
+		return config.Auth{}
+	}
+}
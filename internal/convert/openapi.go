@@ -0,0 +1,793 @@
+package convert
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/killuox/koi/internal/config"
+	"github.com/killuox/koi/internal/samples"
+	"gopkg.in/yaml.v2"
+)
+
+type openAPIDoc struct {
+	OpenAPI    string                     `yaml:"openapi"`
+	Info       openAPIInfo                `yaml:"info"`
+	Servers    []openAPIServer            `yaml:"servers,omitempty"`
+	Paths      map[string]openAPIPathItem `yaml:"paths"`
+	Components openAPIComponents          `yaml:"components,omitempty"`
+}
+
+type openAPIInfo struct {
+	Title       string `yaml:"title"`
+	Description string `yaml:"description,omitempty"`
+	Version     string `yaml:"version"`
+}
+
+type openAPIServer struct {
+	URL         string `yaml:"url"`
+	Description string `yaml:"description,omitempty"`
+}
+
+type openAPIComponents struct {
+	Schemas       map[string]*openAPISchema      `yaml:"schemas,omitempty"`
+	Parameters    map[string]*openAPIParameter   `yaml:"parameters,omitempty"`
+	RequestBodies map[string]*openAPIRequestBody `yaml:"requestBodies,omitempty"`
+}
+
+type openAPIPathItem struct {
+	Parameters []*openAPIParameter `yaml:"parameters,omitempty"`
+	Get        *openAPIOperation   `yaml:"get,omitempty"`
+	Put        *openAPIOperation   `yaml:"put,omitempty"`
+	Post       *openAPIOperation   `yaml:"post,omitempty"`
+	Delete     *openAPIOperation   `yaml:"delete,omitempty"`
+	Options    *openAPIOperation   `yaml:"options,omitempty"`
+	Head       *openAPIOperation   `yaml:"head,omitempty"`
+	Patch      *openAPIOperation   `yaml:"patch,omitempty"`
+	Trace      *openAPIOperation   `yaml:"trace,omitempty"`
+}
+
+type openAPIOperation struct {
+	OperationID string                      `yaml:"operationId,omitempty"`
+	Summary     string                      `yaml:"summary,omitempty"`
+	Description string                      `yaml:"description,omitempty"`
+	Tags        []string                    `yaml:"tags,omitempty"`
+	Parameters  []*openAPIParameter         `yaml:"parameters,omitempty"`
+	RequestBody *openAPIRequestBody         `yaml:"requestBody,omitempty"`
+	Responses   map[string]*openAPIResponse `yaml:"responses"`
+}
+
+type openAPIParameter struct {
+	Ref         string         `yaml:"$ref,omitempty"`
+	Name        string         `yaml:"name,omitempty"`
+	In          string         `yaml:"in,omitempty"`
+	Description string         `yaml:"description,omitempty"`
+	Required    bool           `yaml:"required,omitempty"`
+	Style       string         `yaml:"style,omitempty"`
+	Explode     *bool          `yaml:"explode,omitempty"`
+	Schema      *openAPISchema `yaml:"schema,omitempty"`
+}
+
+type openAPIRequestBody struct {
+	Ref         string                       `yaml:"$ref,omitempty"`
+	Description string                       `yaml:"description,omitempty"`
+	Required    bool                         `yaml:"required,omitempty"`
+	Content     map[string]*openAPIMediaType `yaml:"content,omitempty"`
+}
+
+type openAPIResponse struct {
+	Description string                       `yaml:"description"`
+	Content     map[string]*openAPIMediaType `yaml:"content,omitempty"`
+}
+
+type openAPIMediaType struct {
+	Schema  *openAPISchema `yaml:"schema,omitempty"`
+	Example any            `yaml:"example,omitempty"`
+}
+
+type openAPISchema struct {
+	Ref         string                    `yaml:"$ref,omitempty"`
+	Type        any                       `yaml:"type,omitempty"`
+	Format      string                    `yaml:"format,omitempty"`
+	Description string                    `yaml:"description,omitempty"`
+	Properties  map[string]*openAPISchema `yaml:"properties,omitempty"`
+	Required    []string                  `yaml:"required,omitempty"`
+	Items       *openAPISchema            `yaml:"items,omitempty"`
+	AllOf       []*openAPISchema          `yaml:"allOf,omitempty"`
+	Enum        []any                     `yaml:"enum,omitempty"`
+	Default     any                       `yaml:"default,omitempty"`
+	Example     any                       `yaml:"example,omitempty"`
+	Minimum     *float64                  `yaml:"minimum,omitempty"`
+	Maximum     *float64                  `yaml:"maximum,omitempty"`
+	MinLength   *int                      `yaml:"minLength,omitempty"`
+	MaxLength   *int                      `yaml:"maxLength,omitempty"`
+	MinItems    *int                      `yaml:"minItems,omitempty"`
+	MaxItems    *int                      `yaml:"maxItems,omitempty"`
+	Pattern     string                    `yaml:"pattern,omitempty"`
+	Nullable    bool                      `yaml:"nullable,omitempty"`
+}
+
+// Body types of the request body content types koi can send, json being the default
+var openAPIBodyTypes = map[string]string{
+	"application/json":                  "",
+	"application/x-www-form-urlencoded": "form",
+	"multipart/form-data":               "multipart",
+	"application/xml":                   "xml",
+	"text/xml":                          "xml",
+	"text/plain":                        "raw",
+}
+
+// Format rules checked before sending, from the schema format
+var openAPIFormatRules = map[string]string{
+	"uuid":      "uuid",
+	"email":     "email",
+	"date":      "date",
+	"date-time": "date-time",
+	"uri":       "url",
+}
+
+// Faker modes picked from the schema format
+var openAPIFormatModes = map[string]string{
+	"email":     "faker:email",
+	"uuid":      "faker:uuid",
+	"date-time": "faker:date_time",
+	"date":      "faker:date",
+	"uri":       "faker:url",
+	"url":       "faker:url",
+	"password":  "faker:password",
+}
+
+// ImportOpenAPI converts the operations of an OpenAPI 3 document (YAML or JSON) into endpoints
+func ImportOpenAPI(data []byte) (*Collection, error) {
+	doc := openAPIDoc{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("error parsing OpenAPI document: %w", err)
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		return nil, fmt.Errorf("unsupported OpenAPI version %q, only 3.x documents are supported", doc.OpenAPI)
+	}
+
+	c := &Collection{}
+	if len(doc.Servers) > 0 && strings.HasPrefix(doc.Servers[0].URL, "http") {
+		c.BaseURL = strings.TrimSuffix(doc.Servers[0].URL, "/")
+	}
+
+	paths := make([]string, 0, len(doc.Paths))
+	for path := range doc.Paths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		item := doc.Paths[path]
+		for _, op := range item.operations() {
+			e, err := doc.endpoint(path, op.method, item.Parameters, op.operation, c)
+			if err != nil {
+				return nil, fmt.Errorf("%s %s: %w", op.method, path, err)
+			}
+			c.AddEndpoint(op.operation.OperationID, e)
+		}
+	}
+
+	return c, nil
+}
+
+type methodOperation struct {
+	method    string
+	operation *openAPIOperation
+}
+
+func (p openAPIPathItem) operations() []methodOperation {
+	all := []methodOperation{
+		{http.MethodGet, p.Get},
+		{http.MethodPost, p.Post},
+		{http.MethodPut, p.Put},
+		{http.MethodPatch, p.Patch},
+		{http.MethodDelete, p.Delete},
+		{http.MethodHead, p.Head},
+		{http.MethodOptions, p.Options},
+		{http.MethodTrace, p.Trace},
+	}
+
+	ops := make([]methodOperation, 0, len(all))
+	for _, op := range all {
+		if op.operation != nil {
+			ops = append(ops, op)
+		}
+	}
+	return ops
+}
+
+func (d *openAPIDoc) endpoint(path, method string, shared []*openAPIParameter, op *openAPIOperation, c *Collection) (config.Endpoint, error) {
+	e := config.Endpoint{
+		Method:      method,
+		Path:        path,
+		Description: strings.TrimSpace(firstNonEmpty(op.Summary, op.Description)),
+		Parameters:  map[string]config.Parameter{},
+		Defaults:    map[string]any{},
+	}
+
+	// Operation parameters override the ones shared by the path
+	params := map[string]*openAPIParameter{}
+	for _, list := range [][]*openAPIParameter{shared, op.Parameters} {
+		for _, p := range list {
+			resolved, err := d.parameter(p)
+			if err != nil {
+				return e, err
+			}
+			params[resolved.In+":"+resolved.Name] = resolved
+		}
+	}
+
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		p := params[key]
+		switch p.In {
+		case "path", "query", "header", "cookie":
+		default:
+			c.Warn("%s %s: skipped %s parameter %q", method, path, p.In, p.Name)
+			continue
+		}
+
+		schema, err := d.schema(p.Schema)
+		if err != nil {
+			return e, err
+		}
+		param, ok := d.fieldParameter(p.Schema, schema, c, method+" "+path, p.Name, nil)
+		if !ok {
+			continue
+		}
+		param.In = p.In
+		param.Required = p.Required || p.In == "path"
+		if param.Type == "array" && p.Explode != nil && !*p.Explode && (p.Style == "" || p.Style == "form") {
+			param.Style = "comma"
+		}
+		param.Description = firstNonEmpty(p.Description, param.Description)
+		e.Parameters[p.Name] = param
+		if schema != nil && schema.Default != nil {
+			e.Defaults[p.Name] = schema.Default
+		}
+	}
+
+	if op.RequestBody != nil {
+		if err := d.addBody(&e, op.RequestBody, c); err != nil {
+			return e, err
+		}
+	}
+
+	return e, nil
+}
+
+func (d *openAPIDoc) addBody(e *config.Endpoint, body *openAPIRequestBody, c *Collection) error {
+	body, err := d.requestBody(body)
+	if err != nil {
+		return err
+	}
+
+	var media *openAPIMediaType
+	var contentType string
+	for _, ct := range []string{"application/json", "application/x-www-form-urlencoded", "multipart/form-data", "application/xml", "text/xml", "text/plain"} {
+		if m, ok := body.Content[ct]; ok {
+			media, contentType = m, ct
+			break
+		}
+	}
+	if media == nil {
+		for _, ct := range sortedKeys(body.Content) {
+			c.Warn("%s %s: skipped %s request body", e.Method, e.Path, ct)
+		}
+		return nil
+	}
+	e.BodyType = openAPIBodyTypes[contentType]
+
+	schema, err := d.schema(media.Schema)
+	if err != nil {
+		return err
+	}
+	if e.BodyType == "xml" || e.BodyType == "raw" {
+		// Sent as is, from a single body parameter
+		e.Parameters["body"] = config.Parameter{Type: "string", In: "body", Required: body.Required}
+		return nil
+	}
+	if schema == nil || schemaType(schema) != "object" {
+		c.Warn("%s %s: skipped request body that is not an object", e.Method, e.Path)
+		return nil
+	}
+
+	required := map[string]bool{}
+	for _, name := range schema.Required {
+		required[name] = true
+	}
+
+	names := make([]string, 0, len(schema.Properties))
+	for name := range schema.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var refs []string
+	if media.Schema.Ref != "" {
+		refs = []string{media.Schema.Ref}
+	}
+	for _, name := range names {
+		prop, err := d.schema(schema.Properties[name])
+		if err != nil {
+			return err
+		}
+		param, ok := d.fieldParameter(schema.Properties[name], prop, c, e.Method+" "+e.Path, name, refs)
+		if !ok {
+			continue
+		}
+		param.In = "body"
+		param.Required = body.Required && required[name]
+		e.Parameters[name] = param
+		if prop.Default != nil {
+			e.Defaults[name] = prop.Default
+		}
+	}
+	return nil
+}
+
+// Depth of the nested objects and arrays imported
+const maxSchemaDepth = 5
+
+// fieldParameter maps the schema s of a field, resolved from raw. refs are
+// the schemas it is nested in, a field referencing one of them is recursive
+// and becomes a plain object or array instead of being unrolled
+func (d *openAPIDoc) fieldParameter(raw, s *openAPISchema, c *Collection, operation, name string, refs []string) (config.Parameter, bool) {
+	if raw != nil && raw.Ref != "" {
+		if slices.Contains(refs, raw.Ref) {
+			return config.Parameter{Type: schemaType(s), Description: strings.TrimSpace(s.Description)}, true
+		}
+		refs = append(slices.Clip(refs), raw.Ref)
+	}
+	return d.schemaParameter(s, c, operation, name, refs)
+}
+
+// schemaParameter maps a schema to a parameter, reporting the ones koi cannot describe
+func (d *openAPIDoc) schemaParameter(s *openAPISchema, c *Collection, operation, name string, refs []string) (config.Parameter, bool) {
+	if s == nil {
+		return config.Parameter{Type: "string"}, true
+	}
+
+	param := config.Parameter{Description: strings.TrimSpace(s.Description)}
+	switch schemaType(s) {
+	case "string", "":
+		param.Type = "string"
+		if s.Format == "binary" {
+			param.Type = "file"
+		}
+	case "integer":
+		param.Type = "int"
+	case "number":
+		param.Type = "float"
+	case "boolean":
+		param.Type = "bool"
+	case "object", "array":
+		// Nested fields are named after their path, e.g. address.city
+		if strings.Count(name, ".") >= maxSchemaDepth {
+			c.Warn("%s: skipped %q, nested too deep", operation, name)
+			return param, false
+		}
+		param.Type = schemaType(s)
+		if param.Type == "array" {
+			items, err := d.schema(s.Items)
+			if err != nil {
+				c.Warn("%s: skipped %q: %s", operation, name, err)
+				return param, false
+			}
+			if item, ok := d.fieldParameter(s.Items, items, c, operation, name+".items", refs); ok {
+				param.Items = &item
+			}
+			break
+		}
+		for _, propName := range sortedKeys(s.Properties) {
+			propSchema, err := d.schema(s.Properties[propName])
+			if err != nil {
+				c.Warn("%s: skipped %q: %s", operation, name+"."+propName, err)
+				continue
+			}
+			prop, ok := d.fieldParameter(s.Properties[propName], propSchema, c, operation, name+"."+propName, refs)
+			if !ok {
+				continue
+			}
+			prop.Required = slices.Contains(s.Required, propName)
+			if param.Properties == nil {
+				param.Properties = map[string]config.Parameter{}
+			}
+			param.Properties[propName] = prop
+		}
+	default:
+		c.Warn("%s: skipped %s parameter %q", operation, schemaType(s), name)
+		return param, false
+	}
+
+	if mode, ok := openAPIFormatModes[s.Format]; ok && param.Type == "string" {
+		param.Mode = mode
+	}
+	if s.Minimum != nil {
+		minimum := int(*s.Minimum)
+		param.Rules.Min = &minimum
+	}
+	if s.Maximum != nil {
+		maximum := int(*s.Maximum)
+		param.Rules.Max = &maximum
+	}
+	if s.MinLength != nil {
+		param.Rules.MinLength = *s.MinLength
+	}
+	if s.MaxLength != nil {
+		param.Rules.MaxLength = *s.MaxLength
+	}
+	param.Nullable = s.Nullable || isNullable(s)
+	param.Rules.Enum = s.Enum
+	param.Rules.Pattern = s.Pattern
+	if format, ok := openAPIFormatRules[s.Format]; ok && param.Type == "string" {
+		param.Rules.Format = format
+	}
+	if param.Mode == "" && (param.Type == "int" || param.Type == "float") && s.Maximum != nil {
+		param.Mode = "faker:number"
+	}
+	return param, true
+}
+
+func (d *openAPIDoc) parameter(p *openAPIParameter) (*openAPIParameter, error) {
+	for depth := 0; p != nil && p.Ref != ""; depth++ {
+		name, err := refName(p.Ref, "#/components/parameters/", depth)
+		if err != nil {
+			return nil, err
+		}
+		p = d.Components.Parameters[name]
+	}
+	if p == nil {
+		return nil, fmt.Errorf("unresolved parameter reference")
+	}
+	return p, nil
+}
+
+func (d *openAPIDoc) requestBody(b *openAPIRequestBody) (*openAPIRequestBody, error) {
+	for depth := 0; b != nil && b.Ref != ""; depth++ {
+		name, err := refName(b.Ref, "#/components/requestBodies/", depth)
+		if err != nil {
+			return nil, err
+		}
+		b = d.Components.RequestBodies[name]
+	}
+	if b == nil {
+		return nil, fmt.Errorf("unresolved request body reference")
+	}
+	return b, nil
+}
+
+// schema resolves references and flattens allOf compositions
+func (d *openAPIDoc) schema(s *openAPISchema) (*openAPISchema, error) {
+	for depth := 0; s != nil && s.Ref != ""; depth++ {
+		name, err := refName(s.Ref, "#/components/schemas/", depth)
+		if err != nil {
+			return nil, err
+		}
+		resolved, ok := d.Components.Schemas[name]
+		if !ok {
+			return nil, fmt.Errorf("unresolved schema reference %s", s.Ref)
+		}
+		s = resolved
+	}
+	if s == nil || len(s.AllOf) == 0 {
+		return s, nil
+	}
+
+	merged := *s
+	merged.AllOf = nil
+	merged.Properties = map[string]*openAPISchema{}
+	for name, prop := range s.Properties {
+		merged.Properties[name] = prop
+	}
+	for _, part := range s.AllOf {
+		resolved, err := d.schema(part)
+		if err != nil {
+			return nil, err
+		}
+		if resolved == nil {
+			continue
+		}
+		if merged.Type == nil {
+			merged.Type = resolved.Type
+		}
+		for name, prop := range resolved.Properties {
+			merged.Properties[name] = prop
+		}
+		merged.Required = append(merged.Required, resolved.Required...)
+	}
+	if merged.Type == nil && len(merged.Properties) > 0 {
+		merged.Type = "object"
+	}
+	return &merged, nil
+}
+
+func refName(ref, prefix string, depth int) (string, error) {
+	if depth > 32 {
+		return "", fmt.Errorf("reference cycle at %s", ref)
+	}
+	if !strings.HasPrefix(ref, prefix) {
+		return "", fmt.Errorf("unsupported reference %s", ref)
+	}
+	return strings.TrimPrefix(ref, prefix), nil
+}
+
+// schemaType returns the type of a schema, OpenAPI 3.1 allows a list such as [string, "null"]
+func schemaType(s *openAPISchema) string {
+	switch t := s.Type.(type) {
+	case string:
+		return t
+	case []any:
+		for _, item := range t {
+			if name, ok := item.(string); ok && name != "null" {
+				return name
+			}
+		}
+	}
+	if len(s.Properties) > 0 {
+		return "object"
+	}
+	return ""
+}
+
+// isNullable reports whether an OpenAPI 3.1 type list allows "null"
+func isNullable(s *openAPISchema) bool {
+	types, _ := s.Type.([]any)
+	return slices.Contains(types, any("null"))
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// Parameter types mapped to OpenAPI schema types
+var openAPITypes = map[string]string{
+	"string": "string",
+	"int":    "integer",
+	"float":  "number",
+	"bool":   "boolean",
+	"object": "object",
+	"array":  "array",
+}
+
+// ExportOpenAPI describes the endpoints of a config as an OpenAPI 3 document.
+// Recorded samples, keyed by endpoint name, become response examples
+func ExportOpenAPI(cfg config.Config, recorded map[string]samples.Sample) ([]byte, error) {
+	doc := openAPIDoc{
+		OpenAPI: "3.0.3",
+		Info: openAPIInfo{
+			Title:   filepath.Base(cfg.Dir()),
+			Version: "1.0.0",
+		},
+		Servers: []openAPIServer{{URL: cfg.API.BaseURL}},
+		Paths:   map[string]openAPIPathItem{},
+	}
+	for _, name := range cfg.EnvironmentNames() {
+		if url := cfg.Environments[name].BaseURL; url != "" {
+			doc.Servers = append(doc.Servers, openAPIServer{URL: url, Description: name})
+		}
+	}
+
+	names := make([]string, 0, len(cfg.Endpoints))
+	for name := range cfg.Endpoints {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// OpenAPI has a single operation per path and method
+	operations := map[string]string{}
+	for _, name := range names {
+		e := cfg.Endpoints[name]
+		key := e.Method + " " + e.Path
+		if other, ok := operations[key]; ok {
+			return nil, fmt.Errorf("endpoints %s and %s are both %s, OpenAPI can only describe one of them", other, name, key)
+		}
+		operations[key] = name
+
+		item := doc.Paths[e.Path]
+		op := exportOperation(name, e)
+		if sample, ok := recorded[name]; ok {
+			op.Responses = map[string]*openAPIResponse{
+				strconv.Itoa(sample.Status): exportResponse(sample),
+			}
+		}
+
+		switch e.Method {
+		case http.MethodGet:
+			item.Get = op
+		case http.MethodPost:
+			item.Post = op
+		case http.MethodPut:
+			item.Put = op
+		case http.MethodPatch:
+			item.Patch = op
+		case http.MethodDelete:
+			item.Delete = op
+		case http.MethodHead:
+			item.Head = op
+		case http.MethodOptions:
+			item.Options = op
+		case http.MethodTrace:
+			item.Trace = op
+		default:
+			return nil, fmt.Errorf("endpoint %s: method %s cannot be described in OpenAPI", name, e.Method)
+		}
+		doc.Paths[e.Path] = item
+	}
+
+	return yaml.Marshal(doc)
+}
+
+func exportOperation(name string, e config.Endpoint) *openAPIOperation {
+	op := &openAPIOperation{
+		OperationID: EndpointName(name),
+		Summary:     e.Description,
+		Responses: map[string]*openAPIResponse{
+			"default": {Description: "Response"},
+		},
+	}
+	// Grouped endpoints, e.g. "users list", are tagged with their group
+	if i := strings.LastIndex(name, " "); i >= 0 {
+		op.Tags = []string{name[:i]}
+	}
+
+	params := make([]string, 0, len(e.Parameters))
+	for key := range e.Parameters {
+		params = append(params, key)
+	}
+	sort.Strings(params)
+
+	body := &openAPISchema{Type: "object", Properties: map[string]*openAPISchema{}}
+	for _, key := range params {
+		p := e.Parameters[key]
+		schema := exportSchema(p, e.Defaults[key])
+
+		in := p.Location(e)
+		if in == "body" {
+			body.Properties[key] = schema
+			if p.Required {
+				body.Required = append(body.Required, key)
+			}
+			continue
+		}
+
+		param := &openAPIParameter{
+			Name:        key,
+			In:          in,
+			Description: p.Description,
+			Required:    p.Required || in == "path",
+			Schema:      schema,
+		}
+		if p.Style == "comma" {
+			explode := false
+			param.Style, param.Explode = "form", &explode
+		}
+		op.Parameters = append(op.Parameters, param)
+	}
+
+	if len(body.Properties) > 0 {
+		contentType := "application/json"
+		for ct, bodyType := range openAPIBodyTypes {
+			if bodyType == e.BodyType && ct != "text/xml" {
+				contentType = ct
+			}
+		}
+
+		// Raw and xml bodies are the value of their single parameter
+		schema := body
+		if (e.BodyType == "raw" || e.BodyType == "xml") && len(body.Properties) == 1 {
+			for _, prop := range body.Properties {
+				schema = prop
+			}
+		}
+
+		op.RequestBody = &openAPIRequestBody{
+			Required: len(body.Required) > 0,
+			Content: map[string]*openAPIMediaType{
+				contentType: {Schema: schema},
+			},
+		}
+	}
+	return op
+}
+
+func exportSchema(p config.Parameter, defaultVal any) *openAPISchema {
+	s := &openAPISchema{
+		Type:        openAPITypes[p.Type],
+		Description: p.Description,
+		Default:     defaultVal,
+	}
+	if s.Type == "" {
+		s.Type = "string"
+	}
+	if p.Type == "file" {
+		s.Format = "binary"
+		s.Default = nil
+	}
+
+	// Faker modes tell us the format back
+	for format, mode := range openAPIFormatModes {
+		if p.Mode == mode && format != "url" {
+			s.Format = format
+		}
+	}
+
+	rules := p.Rules
+	if rules.Min != nil {
+		minimum := float64(*rules.Min)
+		s.Minimum = &minimum
+	}
+	if rules.Max != nil {
+		maximum := float64(*rules.Max)
+		s.Maximum = &maximum
+	}
+	if rules.MinLength != 0 {
+		s.MinLength = &rules.MinLength
+	}
+	if rules.MaxLength != 0 {
+		s.MaxLength = &rules.MaxLength
+	}
+	s.Nullable = p.Nullable || p.SendNull
+	s.Enum = rules.Enum
+	s.Pattern = rules.Pattern
+	for format, rule := range openAPIFormatRules {
+		if s.Format == "" && rules.Format == rule {
+			s.Format = format
+		}
+	}
+
+	for _, name := range sortedKeys(p.Properties) {
+		prop := p.Properties[name]
+		if s.Properties == nil {
+			s.Properties = map[string]*openAPISchema{}
+		}
+		s.Properties[name] = exportSchema(prop, nil)
+		if prop.Required {
+			s.Required = append(s.Required, name)
+		}
+	}
+	if p.Items != nil {
+		s.Items = exportSchema(*p.Items, nil)
+	}
+	return s
+}
+
+func exportResponse(sample samples.Sample) *openAPIResponse {
+	contentType := sample.ContentType
+	if i := strings.Index(contentType, ";"); i >= 0 {
+		contentType = strings.TrimSpace(contentType[:i])
+	}
+	if contentType == "" {
+		contentType = "application/json"
+	}
+
+	var example any = sample.Body
+	var decoded any
+	if err := json.Unmarshal([]byte(sample.Body), &decoded); err == nil {
+		example = decoded
+	}
+
+	return &openAPIResponse{
+		Description: "Recorded response",
+		Content: map[string]*openAPIMediaType{
+			contentType: {Example: example},
+		},
+	}
+}
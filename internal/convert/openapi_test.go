@@ -0,0 +1,112 @@
+package convert
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/killuox/koi/internal/config"
+)
+
+const recursiveSpec = `
+openapi: 3.0.0
+info: {title: cats, version: "1"}
+paths:
+  /cats:
+    post:
+      operationId: createCat
+      requestBody:
+        content:
+          application/json:
+            schema: {$ref: '#/components/schemas/Cat'}
+      responses: {"200": {description: ok}}
+  /orders:
+    post:
+      operationId: createOrder
+      requestBody:
+        content:
+          application/json:
+            schema: {$ref: '#/components/schemas/Order'}
+      responses: {"200": {description: ok}}
+components:
+  schemas:
+    Cat:
+      type: object
+      properties:
+        name: {type: string}
+        parent: {$ref: '#/components/schemas/Cat'}
+        children:
+          type: array
+          items: {$ref: '#/components/schemas/Cat'}
+        owner: {$ref: '#/components/schemas/Owner'}
+    Owner:
+      type: object
+      properties:
+        name: {type: string}
+        cats:
+          type: array
+          items: {$ref: '#/components/schemas/Cat'}
+    Order:
+      type: object
+      properties:
+        billing: {$ref: '#/components/schemas/Address'}
+        shipping: {$ref: '#/components/schemas/Address'}
+    Address:
+      type: object
+      properties:
+        city: {type: string}
+`
+
+func TestImportOpenAPIRecursiveSchemas(t *testing.T) {
+	c, err := ImportOpenAPI([]byte(recursiveSpec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Warnings) > 0 {
+		t.Errorf("warnings = %q, want none", c.Warnings)
+	}
+	endpoints := map[string]NamedEndpoint{}
+	for _, e := range c.Endpoints {
+		endpoints[e.Name] = e
+	}
+
+	cat := endpoints["create-cat"].Endpoint.Parameters
+	if p := cat["parent"]; p.Type != "object" || len(p.Properties) > 0 {
+		t.Errorf("parent = %+v, want a plain object", p)
+	}
+	if p := cat["children"]; p.Type != "array" || p.Items == nil || p.Items.Type != "object" || len(p.Items.Properties) > 0 {
+		t.Errorf("children = %+v, want an array of plain objects", p)
+	}
+	// A cycle through another schema stops where Cat comes back
+	owner := cat["owner"]
+	if owner.Properties["name"].Type != "string" {
+		t.Errorf("owner = %+v, want its name property", owner)
+	}
+	if cats := owner.Properties["cats"]; cats.Items == nil || len(cats.Items.Properties) > 0 {
+		t.Errorf("owner.cats = %+v, want an array of plain objects", cats)
+	}
+
+	// The same schema used twice side by side is not a cycle
+	order := endpoints["create-order"].Endpoint.Parameters
+	for _, name := range []string{"billing", "shipping"} {
+		if order[name].Properties["city"].Type != "string" {
+			t.Errorf("%s = %+v, want its city property", name, order[name])
+		}
+	}
+}
+
+func TestExportOpenAPISamePathAndMethod(t *testing.T) {
+	cfg := config.Config{API: config.API{BaseURL: "http://localhost"}, Endpoints: map[string]config.Endpoint{
+		"users list":   {Method: "GET", Path: "/users"},
+		"users search": {Method: "GET", Path: "/users"},
+		"users create": {Method: "POST", Path: "/users"},
+	}}
+	_, err := ExportOpenAPI(cfg, nil)
+	if err == nil || !strings.Contains(err.Error(), "users list and users search are both GET /users") {
+		t.Errorf("err = %v, want both endpoints named", err)
+	}
+
+	delete(cfg.Endpoints, "users search")
+	if _, err := ExportOpenAPI(cfg, nil); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,564 @@
+package convert
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/killuox/koi/internal/config"
+)
+
+const postmanSchema = "https://schema.getpostman.com/json/collection/v2.1.0/collection.json"
+
+type postmanCollection struct {
+	Info     postmanInfo       `json:"info"`
+	Item     []postmanItem     `json:"item"`
+	Variable []postmanKeyValue `json:"variable,omitempty"`
+	Auth     *postmanAuth      `json:"auth,omitempty"`
+	Event    []postmanEvent    `json:"event,omitempty"`
+}
+
+type postmanInfo struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Schema      string `json:"schema"`
+}
+
+// postmanItem is either a folder, with items, or a request
+type postmanItem struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Item        []postmanItem   `json:"item,omitempty"`
+	Request     *postmanRequest `json:"request,omitempty"`
+	Auth        *postmanAuth    `json:"auth,omitempty"`
+	Event       []postmanEvent  `json:"event,omitempty"`
+}
+
+type postmanRequest struct {
+	Method      string            `json:"method"`
+	Header      []postmanKeyValue `json:"header,omitempty"`
+	URL         postmanURL        `json:"url"`
+	Body        *postmanBody      `json:"body,omitempty"`
+	Auth        *postmanAuth      `json:"auth,omitempty"`
+	Description string            `json:"description,omitempty"`
+}
+
+type postmanURL struct {
+	Raw      string            `json:"raw"`
+	Host     []string          `json:"host,omitempty"`
+	Path     []string          `json:"path,omitempty"`
+	Query    []postmanKeyValue `json:"query,omitempty"`
+	Variable []postmanKeyValue `json:"variable,omitempty"`
+}
+
+// UnmarshalJSON accepts the short form where the url is a plain string
+func (u *postmanURL) UnmarshalJSON(data []byte) error {
+	var raw string
+	if err := json.Unmarshal(data, &raw); err == nil {
+		u.Raw = raw
+		return nil
+	}
+	type plain postmanURL
+	return json.Unmarshal(data, (*plain)(u))
+}
+
+type postmanBody struct {
+	Mode       string            `json:"mode"`
+	Raw        string            `json:"raw,omitempty"`
+	URLEncoded []postmanKeyValue `json:"urlencoded,omitempty"`
+	FormData   []postmanKeyValue `json:"formdata,omitempty"`
+	Options    map[string]any    `json:"options,omitempty"`
+}
+
+type postmanKeyValue struct {
+	Key         string `json:"key"`
+	Value       any    `json:"value"`
+	Type        string `json:"type,omitempty"`
+	Description string `json:"description,omitempty"`
+	Disabled    bool   `json:"disabled,omitempty"`
+	Enabled     *bool  `json:"enabled,omitempty"`
+	// Path of a formdata file, a list when several files are selected
+	Src any `json:"src,omitempty"`
+}
+
+func (kv postmanKeyValue) enabled() bool {
+	return !kv.Disabled && (kv.Enabled == nil || *kv.Enabled)
+}
+
+func (kv postmanKeyValue) text() string {
+	if kv.Value == nil {
+		return ""
+	}
+	if s, ok := kv.Value.(string); ok {
+		return s
+	}
+	return fmt.Sprintf("%v", kv.Value)
+}
+
+type postmanAuth struct {
+	Type   string            `json:"type"`
+	Bearer []postmanKeyValue `json:"bearer,omitempty"`
+	Basic  []postmanKeyValue `json:"basic,omitempty"`
+	APIKey []postmanKeyValue `json:"apikey,omitempty"`
+	Digest []postmanKeyValue `json:"digest,omitempty"`
+	OAuth2 []postmanKeyValue `json:"oauth2,omitempty"`
+	AWSv4  []postmanKeyValue `json:"awsv4,omitempty"`
+}
+
+// koi oauth2 grants, by Postman grant type. koi always uses PKCE with the
+// authorization code grant
+var postmanGrants = map[string]string{
+	"client_credentials":           "client_credentials",
+	"password_credentials":         "password",
+	"authorization_code":           "authorization_code",
+	"authorization_code_with_pkce": "authorization_code",
+}
+
+type postmanEvent struct {
+	Listen string `json:"listen"`
+}
+
+type postmanEnvironment struct {
+	Name   string            `json:"name"`
+	Values []postmanKeyValue `json:"values"`
+}
+
+// Postman dynamic variables that have a koi equivalent
+var postmanDynamicVars = map[string]string{
+	"$guid":         "{{uuid}}",
+	"$randomUUID":   "{{uuid}}",
+	"$timestamp":    "{{timestamp}}",
+	"$isoTimestamp": "{{now}}",
+}
+
+var postmanVarRe = regexp.MustCompile(`\{\{(\$\w+)\}\}`)
+
+// ImportPostman converts a Postman v2.1 collection, and optionally Postman
+// environment files, into endpoints, profiles and variables
+func ImportPostman(collection []byte, environments ...[]byte) (*Collection, error) {
+	pc := postmanCollection{}
+	if err := json.Unmarshal(collection, &pc); err != nil {
+		return nil, fmt.Errorf("error parsing Postman collection: %w", err)
+	}
+	if pc.Info.Schema != "" && !strings.Contains(pc.Info.Schema, "v2.") {
+		return nil, fmt.Errorf("unsupported Postman collection schema %s, export it as v2.1", pc.Info.Schema)
+	}
+
+	c := &Collection{Variables: map[string]any{}, Environments: map[string]config.Environment{}}
+	for _, v := range pc.Variable {
+		c.Variables[v.Key] = c.postmanText(v.text())
+	}
+	c.warnEvents(pc.Info.Name, pc.Event)
+	c.Auth = c.postmanAuth(pc.Auth, "collection")
+
+	for _, raw := range environments {
+		env := postmanEnvironment{}
+		if err := json.Unmarshal(raw, &env); err != nil {
+			return nil, fmt.Errorf("error parsing Postman environment: %w", err)
+		}
+		profile := config.Environment{Variables: map[string]any{}}
+		for _, v := range env.Values {
+			if v.enabled() {
+				profile.Variables[v.Key] = c.postmanText(v.text())
+			}
+		}
+		c.Environments[EndpointName(env.Name)] = profile
+	}
+
+	for _, item := range pc.Item {
+		c.postmanItem(item, "")
+	}
+	return c, nil
+}
+
+func (c *Collection) postmanItem(item postmanItem, prefix string) {
+	name := strings.TrimSpace(prefix + " " + item.Name)
+	c.warnEvents(name, item.Event)
+
+	if item.Request == nil {
+		if item.Auth != nil {
+			c.Warn("%s: folder auth is not supported, set it on the requests", name)
+		}
+		for _, child := range item.Item {
+			c.postmanItem(child, name)
+		}
+		return
+	}
+
+	req := item.Request
+	base, path, query := splitURL(c.postmanText(req.URL.Raw))
+	if c.BaseURL == "" {
+		c.BaseURL = base
+	}
+
+	e := config.Endpoint{
+		Method:      strings.ToUpper(req.Method),
+		Path:        path,
+		Description: firstNonEmpty(item.Description, req.Description),
+	}
+	if e.Method == "" {
+		e.Method = "GET"
+	}
+
+	for _, v := range req.URL.Variable {
+		setParameter(&e, v.Key, config.Parameter{Type: "string", In: "path", Required: true, Description: v.Description}, c.postmanText(v.text()))
+	}
+	for _, q := range query {
+		setParameter(&e, q.key, config.Parameter{Type: "string", In: "query"}, c.postmanText(q.value))
+	}
+	for _, match := range pathParamRe.FindAllStringSubmatch(path, -1) {
+		if _, ok := e.Parameters[match[1]]; !ok {
+			setParameter(&e, match[1], config.Parameter{Type: "string", In: "path", Required: true}, nil)
+		}
+	}
+
+	for _, h := range req.Header {
+		if h.enabled() && !strings.EqualFold(h.Key, "Content-Type") {
+			setHeader(&e, h.Key, c.postmanText(h.text()))
+		}
+	}
+	e.Auth = c.postmanAuth(firstAuth(req.Auth, item.Auth), name)
+
+	if req.Body != nil {
+		switch req.Body.Mode {
+		case "raw":
+			language := ""
+			if raw, ok := req.Body.Options["raw"].(map[string]any); ok {
+				language, _ = raw["language"].(string)
+			}
+			if strings.TrimSpace(req.Body.Raw) != "" {
+				c.addRawBody(&e, c.postmanText(req.Body.Raw), language)
+			}
+		case "urlencoded", "formdata":
+			fields := req.Body.URLEncoded
+			e.BodyType = "form"
+			if req.Body.Mode == "formdata" {
+				fields = req.Body.FormData
+				e.BodyType = "multipart"
+			}
+			for _, f := range fields {
+				if !f.enabled() {
+					continue
+				}
+				if f.Type == "file" {
+					src, _ := f.Src.(string)
+					setParameter(&e, f.Key, config.Parameter{Type: "file", In: "body"}, src)
+					continue
+				}
+				setParameter(&e, f.Key, config.Parameter{Type: "string", In: "body"}, c.postmanText(f.text()))
+			}
+		case "":
+		default:
+			c.Warn("%s: skipped %s body", name, req.Body.Mode)
+		}
+	}
+
+	c.AddEndpoint(name, e)
+}
+
+// postmanAuth maps Postman auth to koi auth, noauth being auth: none
+func (c *Collection) postmanAuth(auth *postmanAuth, owner string) config.Auth {
+	if auth == nil {
+		return config.Auth{}
+	}
+
+	attrs := func(list []postmanKeyValue) map[string]string {
+		m := map[string]string{}
+		for _, kv := range list {
+			m[kv.Key] = c.postmanText(kv.text())
+		}
+		return m
+	}
+
+	switch auth.Type {
+	case "":
+		return config.Auth{}
+	case "noauth":
+		return config.Auth{Type: "none"}
+	case "bearer":
+		return config.Auth{Type: "bearer", Token: attrs(auth.Bearer)["token"]}
+	case "basic":
+		a := attrs(auth.Basic)
+		return config.Auth{Type: "basic", Username: a["username"], Password: a["password"]}
+	case "digest":
+		a := attrs(auth.Digest)
+		return config.Auth{Type: "digest", Username: a["username"], Password: a["password"]}
+	case "apikey":
+		a := attrs(auth.APIKey)
+		in := "header"
+		if a["in"] == "query" {
+			in = "query"
+		}
+		return config.Auth{Type: "api-key", Name: a["key"], Key: a["value"], In: in}
+	case "oauth2":
+		a := attrs(auth.OAuth2)
+		grant, ok := postmanGrants[cmp.Or(a["grant_type"], "authorization_code")]
+		if !ok {
+			c.Warn("%s: oauth2 %s grant is not supported", owner, a["grant_type"])
+			return config.Auth{}
+		}
+		clientAuth := ""
+		if a["client_authentication"] == "body" {
+			clientAuth = "body"
+		}
+		return config.Auth{
+			Type: "oauth2", Grant: grant, TokenURL: a["accessTokenUrl"], AuthURL: a["authUrl"], RedirectURL: a["redirect_uri"],
+			ClientID: a["clientId"], ClientSecret: a["clientSecret"], ClientAuth: clientAuth, Scopes: strings.Fields(a["scope"]),
+			Username: a["username"], Password: a["password"],
+		}
+	case "awsv4":
+		a := attrs(auth.AWSv4)
+		return config.Auth{
+			Type: "aws-sigv4", Service: a["service"], Region: a["region"],
+			AccessKeyID: a["accessKey"], SecretAccessKey: a["secretKey"], SessionToken: a["sessionToken"],
+		}
+	default:
+		c.Warn("%s: %s auth is not supported", owner, auth.Type)
+		return config.Auth{}
+	}
+}
+
+// exportPostmanAuth maps koi auth to Postman auth
+func exportPostmanAuth(a config.Auth) *postmanAuth {
+	switch a.Type {
+	case "none":
+		return &postmanAuth{Type: "noauth"}
+	case "bearer":
+		return &postmanAuth{Type: "bearer", Bearer: []postmanKeyValue{{Key: "token", Value: a.Token}}}
+	case "basic", "digest":
+		credentials := []postmanKeyValue{{Key: "username", Value: a.Username}, {Key: "password", Value: a.Password}}
+		if a.Type == "digest" {
+			return &postmanAuth{Type: "digest", Digest: credentials}
+		}
+		return &postmanAuth{Type: "basic", Basic: credentials}
+	case "api-key":
+		in, name := "header", cmp.Or(a.Name, "X-API-Key")
+		if a.In == "query" {
+			in, name = "query", cmp.Or(a.Name, "api_key")
+		}
+		return &postmanAuth{Type: "apikey", APIKey: []postmanKeyValue{{Key: "key", Value: name}, {Key: "value", Value: a.Key}, {Key: "in", Value: in}}}
+	case "oauth2":
+		// Postman has no refresh_token grant, koi gets a token with its own refresh token
+		grant, clientAuth := "client_credentials", "header"
+		switch a.Grant {
+		case "password":
+			grant = "password_credentials"
+		case "authorization_code", "authorization_code+pkce":
+			grant = "authorization_code_with_pkce"
+		}
+		if a.ClientAuth == "body" {
+			clientAuth = "body"
+		}
+		attrs := []postmanKeyValue{
+			{Key: "grant_type", Value: grant},
+			{Key: "accessTokenUrl", Value: a.TokenURL},
+			{Key: "authUrl", Value: a.AuthURL},
+			{Key: "redirect_uri", Value: a.RedirectURL},
+			{Key: "clientId", Value: a.ClientID},
+			{Key: "clientSecret", Value: a.ClientSecret},
+			{Key: "client_authentication", Value: clientAuth},
+			{Key: "scope", Value: strings.Join(a.Scopes, " ")},
+			{Key: "username", Value: a.Username},
+			{Key: "password", Value: a.Password},
+		}
+		return &postmanAuth{Type: "oauth2", OAuth2: attrs}
+	case "aws-sigv4":
+		return &postmanAuth{Type: "awsv4", AWSv4: []postmanKeyValue{
+			{Key: "accessKey", Value: a.AccessKeyID},
+			{Key: "secretKey", Value: a.SecretAccessKey},
+			{Key: "sessionToken", Value: a.SessionToken},
+			{Key: "region", Value: a.Region},
+			{Key: "service", Value: a.Service},
+		}}
+	default:
+		return nil
+	}
+}
+
+// postmanText translates Postman dynamic variables such as {{$guid}}
+func (c *Collection) postmanText(s string) string {
+	return postmanVarRe.ReplaceAllStringFunc(s, func(match string) string {
+		name := postmanVarRe.FindStringSubmatch(match)[1]
+		if translated, ok := postmanDynamicVars[name]; ok {
+			return translated
+		}
+		c.Warn("dynamic variable %s is not supported", name)
+		return match
+	})
+}
+
+func (c *Collection) warnEvents(owner string, events []postmanEvent) {
+	for _, e := range events {
+		c.Warn("%s: %s scripts are not supported", owner, e.Listen)
+	}
+}
+
+func firstAuth(auths ...*postmanAuth) *postmanAuth {
+	for _, a := range auths {
+		if a != nil {
+			return a
+		}
+	}
+	return nil
+}
+
+// ExportPostman describes the endpoints of a config as a Postman v2.1 collection
+func ExportPostman(cfg config.Config) ([]byte, error) {
+	pc := postmanCollection{
+		Info: postmanInfo{
+			Name:   filepath.Base(cfg.Dir()),
+			Schema: postmanSchema,
+		},
+		Item:     []postmanItem{},
+		Variable: []postmanKeyValue{{Key: "baseUrl", Value: cfg.API.BaseURL}},
+		Auth:     exportPostmanAuth(cfg.API.Auth),
+	}
+	for _, name := range sortedKeys(cfg.Variables) {
+		if name == "baseUrl" {
+			continue
+		}
+		pc.Variable = append(pc.Variable, postmanKeyValue{Key: name, Value: cfg.Variables[name]})
+	}
+
+	for _, name := range sortedKeys(cfg.Endpoints) {
+		// Grouped endpoints, e.g. "users list", go into a folder per group
+		folders := strings.Split(name, " ")
+		items, groups := &pc.Item, cfg.Groups
+		for _, folder := range folders[:len(folders)-1] {
+			items = postmanFolder(items, folder, groups[folder].Description)
+			groups = groups[folder].Groups
+		}
+		*items = append(*items, postmanExportItem(folders[len(folders)-1], cfg.Endpoints[name], cfg.API.Headers))
+	}
+
+	return json.MarshalIndent(pc, "", "  ")
+}
+
+// postmanFolder returns the items of the folder named after a group, adding it when missing
+func postmanFolder(items *[]postmanItem, name, description string) *[]postmanItem {
+	for i := range *items {
+		if (*items)[i].Request == nil && (*items)[i].Name == name {
+			return &(*items)[i].Item
+		}
+	}
+	*items = append(*items, postmanItem{Name: name, Description: description})
+	return &(*items)[len(*items)-1].Item
+}
+
+func postmanExportItem(name string, e config.Endpoint, apiHeaders map[string]string) postmanItem {
+	var headers []postmanKeyValue
+	for _, key := range sortedKeys(apiHeaders) {
+		if _, overridden := e.Headers[key]; !overridden {
+			headers = append(headers, postmanKeyValue{Key: key, Value: apiHeaders[key]})
+		}
+	}
+	for _, key := range sortedKeys(e.Headers) {
+		headers = append(headers, postmanKeyValue{Key: key, Value: e.Headers[key]})
+	}
+
+	path := pathParamRe.ReplaceAllString(e.Path, ":$1")
+	u := postmanURL{
+		Host: []string{"{{baseUrl}}"},
+		Path: strings.Split(strings.Trim(path, "/"), "/"),
+	}
+
+	body := map[string]any{}
+	var cookies []string
+	for _, key := range sortedKeys(e.Parameters) {
+		p := e.Parameters[key]
+		val := e.Defaults[key]
+		switch p.Location(e) {
+		case "header":
+			if val == nil {
+				val = "{{" + key + "}}"
+			}
+			headers = append(headers, postmanKeyValue{Key: key, Value: val, Description: p.Description, Disabled: !p.Required && e.Defaults[key] == nil})
+		case "cookie":
+			if val == nil {
+				val = "{{" + key + "}}"
+			}
+			cookies = append(cookies, fmt.Sprintf("%s=%v", key, val))
+		case "path":
+			u.Variable = append(u.Variable, postmanKeyValue{Key: key, Value: val, Description: p.Description})
+		case "query":
+			u.Query = append(u.Query, postmanKeyValue{Key: key, Value: val, Description: p.Description, Disabled: !p.Required && val == nil})
+		case "body":
+			if val == nil {
+				val = "{{" + key + "}}"
+			}
+			body[key] = val
+		}
+	}
+
+	if len(cookies) > 0 {
+		headers = append(headers, postmanKeyValue{Key: "Cookie", Value: strings.Join(cookies, "; ")})
+	}
+
+	u.Raw = "{{baseUrl}}" + path
+	if len(u.Query) > 0 {
+		pairs := make([]string, 0, len(u.Query))
+		for _, q := range u.Query {
+			if !q.Disabled {
+				pairs = append(pairs, q.Key+"="+q.text())
+			}
+		}
+		if len(pairs) > 0 {
+			u.Raw += "?" + strings.Join(pairs, "&")
+		}
+	}
+
+	req := &postmanRequest{
+		Method:      e.Method,
+		Header:      headers,
+		URL:         u,
+		Description: e.Description,
+		Auth:        exportPostmanAuth(e.Auth),
+	}
+	if len(body) > 0 {
+		req.Body = postmanExportBody(e, body)
+	}
+
+	return postmanItem{Name: name, Request: req}
+}
+
+func postmanExportBody(e config.Endpoint, body map[string]any) *postmanBody {
+	switch e.BodyType {
+	case "form", "multipart":
+		var fields []postmanKeyValue
+		for _, key := range sortedKeys(body) {
+			field := postmanKeyValue{Key: key, Value: body[key]}
+			if e.Parameters[key].Type == "file" {
+				field = postmanKeyValue{Key: key, Type: "file", Src: strings.TrimPrefix(fmt.Sprintf("%v", body[key]), "@")}
+			}
+			fields = append(fields, field)
+		}
+		if e.BodyType == "form" {
+			return &postmanBody{Mode: "urlencoded", URLEncoded: fields}
+		}
+		return &postmanBody{Mode: "formdata", FormData: fields}
+	case "raw", "xml":
+		if len(body) == 1 {
+			language := "text"
+			if e.BodyType == "xml" {
+				language = "xml"
+			}
+			for _, val := range body {
+				return &postmanBody{
+					Mode:    "raw",
+					Raw:     fmt.Sprintf("%v", val),
+					Options: map[string]any{"raw": map[string]any{"language": language}},
+				}
+			}
+		}
+	}
+
+	raw, _ := json.MarshalIndent(body, "", "  ")
+	return &postmanBody{
+		Mode:    "raw",
+		Raw:     string(raw),
+		Options: map[string]any{"raw": map[string]any{"language": "json"}},
+	}
+}
@@ -0,0 +1,204 @@
+package convert
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/killuox/koi/internal/api"
+)
+
+// Snippet formats, keyed by the name used in --as-<name> flags
+var Snippets = map[string]func(req *http.Request) (string, error){
+	"curl":   CurlSnippet,
+	"httpie": HTTPieSnippet,
+	"go":     GoSnippet,
+}
+
+// CurlSnippet renders a request as a curl command
+func CurlSnippet(req *http.Request) (string, error) {
+	body, err := api.BodyBytes(req)
+	if err != nil {
+		return "", err
+	}
+
+	fields, isMultipart := multipartFields(req, body)
+
+	// curl -X HEAD waits for a body that never comes, --head does not
+	method := "-X " + req.Method
+	if req.Method == http.MethodHead {
+		method = "--head"
+	}
+	parts := []string{"curl " + method + " " + shellQuote(req.URL.String())}
+	if socket, ok := api.UnixSocket(req); ok {
+		parts = append(parts, "--unix-socket "+shellQuote(socket))
+	}
+	for _, name := range sortedHeaderNames(req.Header) {
+		// curl sets the multipart Content-Type along with its boundary
+		if isMultipart && name == "Content-Type" {
+			continue
+		}
+		for _, val := range req.Header[name] {
+			parts = append(parts, "-H "+shellQuote(name+": "+val))
+		}
+	}
+	switch {
+	case isMultipart:
+		for _, f := range fields {
+			if f.file != "" {
+				parts = append(parts, "-F "+shellQuote(f.name+"=@"+f.file+";type="+f.contentType))
+			} else {
+				parts = append(parts, "-F "+shellQuote(f.name+"="+f.value))
+			}
+		}
+	case len(body) > 0:
+		parts = append(parts, "--data-raw "+shellQuote(string(body)))
+	}
+	return strings.Join(parts, " \\\n  ") + "\n", nil
+}
+
+// HTTPieSnippet renders a request as an HTTPie command
+func HTTPieSnippet(req *http.Request) (string, error) {
+	body, err := api.BodyBytes(req)
+	if err != nil {
+		return "", err
+	}
+
+	fields, isMultipart := multipartFields(req, body)
+
+	parts := []string{"http " + req.Method + " " + shellQuote(req.URL.String())}
+	if isMultipart {
+		parts[0] = "http --multipart " + req.Method + " " + shellQuote(req.URL.String())
+	}
+	for _, name := range sortedHeaderNames(req.Header) {
+		if isMultipart && name == "Content-Type" {
+			continue
+		}
+		for _, val := range req.Header[name] {
+			parts = append(parts, shellQuote(name+":"+val))
+		}
+	}
+	switch {
+	case isMultipart:
+		for _, f := range fields {
+			if f.file != "" {
+				parts = append(parts, shellQuote(f.name+"@"+f.file+";type="+f.contentType))
+			} else {
+				parts = append(parts, shellQuote(f.name+"="+f.value))
+			}
+		}
+	case len(body) > 0:
+		parts = append(parts, "--raw "+shellQuote(string(body)))
+	}
+	return strings.Join(parts, " \\\n  ") + "\n", nil
+}
+
+// GoSnippet renders a request as a Go program using net/http
+func GoSnippet(req *http.Request) (string, error) {
+	body, err := api.BodyBytes(req)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	sb.WriteString("package main\n\nimport (\n\t\"fmt\"\n\t\"io\"\n\t\"net/http\"\n")
+	if len(body) > 0 {
+		sb.WriteString("\t\"strings\"\n")
+	}
+	sb.WriteString(")\n\nfunc main() {\n")
+
+	bodyArg := "nil"
+	if len(body) > 0 {
+		fmt.Fprintf(&sb, "\tbody := strings.NewReader(%s)\n", goQuote(string(body)))
+		bodyArg = "body"
+	}
+	fmt.Fprintf(&sb, "\treq, err := http.NewRequest(%q, %q, %s)\n", req.Method, req.URL.String(), bodyArg)
+	sb.WriteString("\tif err != nil {\n\t\tpanic(err)\n\t}\n")
+	for _, name := range sortedHeaderNames(req.Header) {
+		for _, val := range req.Header[name] {
+			fmt.Fprintf(&sb, "\treq.Header.Add(%q, %q)\n", name, val)
+		}
+	}
+	sb.WriteString(`
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		panic(err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(resp.Status)
+	fmt.Println(string(respBody))
+}
+`)
+	return sb.String(), nil
+}
+
+// multipartField is a field of a multipart body, file being the path of files
+type multipartField struct {
+	name        string
+	value       string
+	file        string
+	contentType string
+}
+
+// multipartFields parses a multipart body back into its fields, files
+// being referenced by the path they were read from
+func multipartFields(req *http.Request, body []byte) ([]multipartField, bool) {
+	mediaType, params, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" {
+		return nil, false
+	}
+
+	var fields []multipartField
+	r := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	for {
+		part, err := r.NextPart()
+		if err != nil {
+			break
+		}
+		f := multipartField{name: part.FormName(), file: part.FileName(), contentType: part.Header.Get("Content-Type")}
+		if f.file == "" {
+			value, _ := io.ReadAll(part)
+			f.value = string(value)
+		} else if path, ok := api.UploadPath(req, f.name); ok {
+			f.file = path
+		}
+		fields = append(fields, f)
+	}
+	return fields, true
+}
+
+func sortedHeaderNames(h http.Header) []string {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func shellQuote(s string) string {
+	if s != "" && strings.IndexFunc(s, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./:=@%+,", r))
+	}) < 0 {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func goQuote(s string) string {
+	if !strings.Contains(s, "`") {
+		return "`" + s + "`"
+	}
+	return strconv.Quote(s)
+}
@@ -0,0 +1,99 @@
+package convert
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/killuox/koi/internal/api"
+	"github.com/killuox/koi/internal/config"
+	"github.com/killuox/koi/internal/cookies"
+	"github.com/killuox/koi/internal/shared"
+)
+
+func TestSnippetsMultipartFilePath(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "avatar.txt")
+	if err := os.WriteFile(path, []byte("hello"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	e := config.Endpoint{Method: "POST", Path: "/upload", BodyType: "multipart", Parameters: map[string]config.Parameter{
+		"avatar": {Type: "file", In: "body"},
+		"title":  {Type: "string", In: "body"},
+	}}
+	s := &shared.State{
+		Cfg:       config.Config{API: config.API{BaseURL: "http://localhost"}},
+		Flags:     map[string]any{"avatar": path, "title": "me"},
+		Variables: map[string]any{},
+	}
+	req, err := api.NewRequest(e, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Files are referenced by the path they were read from, not their base name
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{format: "curl", want: "-F 'avatar=@" + path + ";type=text/plain; charset=utf-8'"},
+		{format: "httpie", want: "'avatar@" + path + ";type=text/plain; charset=utf-8'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			snippet, err := Snippets[tt.format](req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(snippet, tt.want) {
+				t.Errorf("snippet = %s, want %s", snippet, tt.want)
+			}
+		})
+	}
+}
+
+func TestSnippetIsNotSent(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	tokenRequests := 0
+	tokens := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		tokenRequests++
+	}))
+	defer tokens.Close()
+
+	jar, err := cookies.Load("koi.config.yaml", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse("http://localhost/me")
+	jar.SetCookies(u, []*http.Cookie{{Name: "session", Value: "s1"}})
+
+	s := &shared.State{
+		Cfg: config.Config{API: config.API{
+			BaseURL: "http://localhost",
+			Auth:    config.Auth{Type: "oauth2", TokenURL: tokens.URL, ClientID: "koi", ClientSecret: "s3cret"},
+		}},
+		Flags:     map[string]any{},
+		Variables: map[string]any{},
+		Jar:       jar,
+	}
+	req, err := api.NewRequest(config.Endpoint{Method: "GET", Path: "/me"}, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snippet, err := CurlSnippet(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tokenRequests > 0 {
+		t.Errorf("token requests = %d, want none for a snippet", tokenRequests)
+	}
+	for _, want := range []string{"-H 'Authorization: Bearer <oauth2 token>'", "-H 'Cookie: session=s1'"} {
+		if !strings.Contains(snippet, want) {
+			t.Errorf("snippet = %s, want %s", snippet, want)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package cookies
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/killuox/koi/internal/userdata"
+	"golang.org/x/net/publicsuffix"
+)
+
+// Cookie is a cookie stored in the jar
+type Cookie struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Domain string `json:"domain"`
+	Path   string `json:"path"`
+	// Zero for session cookies, which are kept until the jar is cleared
+	Expires  time.Time `json:"expires"`
+	Secure   bool      `json:"secure,omitempty"`
+	HttpOnly bool      `json:"httpOnly,omitempty"`
+	// Host only cookies are only sent to their domain, not its subdomains
+	HostOnly bool `json:"hostOnly,omitempty"`
+}
+
+func (c Cookie) expired() bool {
+	return !c.Expires.IsZero() && c.Expires.Before(time.Now())
+}
+
+func (c Cookie) key() string {
+	return c.Domain + ";" + c.Path + ";" + c.Name
+}
+
+// Cookies are grouped by config file and then by profile so projects and
+// environments don't share their sessions
+type store map[string]map[string][]Cookie
+
+// Jar is a cookie jar persisted under ~/.koi/ between invocations
+type Jar struct {
+	jar     *cookiejar.Jar
+	cookies map[string]Cookie
+	project string
+	profile string
+}
+
+// Load loads the jar of a config file and profile
+func Load(configPath, profile string) (*Jar, error) {
+	data := store{}
+	if err := userdata.Load("cookies", &data); err != nil {
+		return nil, err
+	}
+	project, err := userdata.ProjectKey(configPath)
+	if err != nil {
+		return nil, err
+	}
+	jar, err := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	if err != nil {
+		return nil, err
+	}
+
+	j := &Jar{jar: jar, cookies: map[string]Cookie{}, project: project, profile: cmp.Or(profile, "default")}
+	for _, c := range data[j.project][j.profile] {
+		if c.expired() {
+			continue
+		}
+		j.cookies[c.key()] = c
+
+		// Replay the cookie as if its domain had set it
+		u := &url.URL{Scheme: "http", Host: c.Domain, Path: c.Path}
+		if c.Secure {
+			u.Scheme = "https"
+		}
+		hc := &http.Cookie{Name: c.Name, Value: c.Value, Path: c.Path, Expires: c.Expires, Secure: c.Secure, HttpOnly: c.HttpOnly}
+		if !c.HostOnly {
+			hc.Domain = c.Domain
+		}
+		j.jar.SetCookies(u, []*http.Cookie{hc})
+	}
+	return j, nil
+}
+
+// SetCookies implements http.CookieJar
+func (j *Jar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.jar.SetCookies(u, cookies)
+
+	host := strings.ToLower(u.Hostname())
+	for _, hc := range cookies {
+		c := Cookie{Name: hc.Name, Value: hc.Value, Path: hc.Path, Secure: hc.Secure, HttpOnly: hc.HttpOnly}
+
+		c.Domain = strings.ToLower(strings.TrimPrefix(hc.Domain, "."))
+		if c.Domain == "" {
+			c.Domain, c.HostOnly = host, true
+		} else if host != c.Domain && !strings.HasSuffix(host, "."+c.Domain) {
+			// The jar rejects cookies for other domains
+			continue
+		}
+		if !strings.HasPrefix(c.Path, "/") {
+			c.Path = defaultPath(u.Path)
+		}
+
+		switch {
+		case hc.MaxAge < 0:
+			c.Expires = time.Unix(0, 0)
+		case hc.MaxAge > 0:
+			c.Expires = time.Now().Add(time.Duration(hc.MaxAge) * time.Second)
+		case !hc.Expires.IsZero():
+			c.Expires = hc.Expires
+		}
+
+		// Expired cookies delete the ones they replace
+		if c.expired() {
+			delete(j.cookies, c.key())
+			continue
+		}
+		j.cookies[c.key()] = c
+	}
+}
+
+// Cookies implements http.CookieJar
+func (j *Jar) Cookies(u *url.URL) []*http.Cookie {
+	return j.jar.Cookies(u)
+}
+
+// List returns the cookies of the jar sorted by domain, path and name
+func (j *Jar) List() []Cookie {
+	list := make([]Cookie, 0, len(j.cookies))
+	for _, c := range j.cookies {
+		if !c.expired() {
+			list = append(list, c)
+		}
+	}
+	slices.SortFunc(list, func(a, b Cookie) int {
+		return cmp.Or(cmp.Compare(a.Domain, b.Domain), cmp.Compare(a.Path, b.Path), cmp.Compare(a.Name, b.Name))
+	})
+	return list
+}
+
+// Save writes the cookies of the jar back to ~/.koi/cookies.json
+func (j *Jar) Save() error {
+	return update(func(data store) {
+		list := j.List()
+		if len(list) > 0 {
+			if data[j.project] == nil {
+				data[j.project] = map[string][]Cookie{}
+			}
+			data[j.project][j.profile] = list
+			return
+		}
+		delete(data[j.project], j.profile)
+		if len(data[j.project]) == 0 {
+			delete(data, j.project)
+		}
+	})
+}
+
+// Clear removes every cookie of the jar
+func (j *Jar) Clear() error {
+	j.cookies = map[string]Cookie{}
+	return j.Save()
+}
+
+// WriteNetscape writes the cookies in the Netscape cookies.txt format read
+// by curl -b and wget --load-cookies
+func (j *Jar) WriteNetscape(w io.Writer) error {
+	if _, err := fmt.Fprintln(w, "# Netscape HTTP Cookie File"); err != nil {
+		return err
+	}
+	for _, c := range j.List() {
+		domain, subdomains := c.Domain, "FALSE"
+		if !c.HostOnly {
+			domain, subdomains = "."+c.Domain, "TRUE"
+		}
+		if c.HttpOnly {
+			domain = "#HttpOnly_" + domain
+		}
+		var expires int64
+		if !c.Expires.IsZero() {
+			expires = c.Expires.Unix()
+		}
+		_, err := fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+			domain, subdomains, c.Path, strings.ToUpper(fmt.Sprint(c.Secure)), expires, c.Name, c.Value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// defaultPath is the directory of the request path (RFC 6265 5.1.4)
+func defaultPath(path string) string {
+	i := strings.LastIndex(path, "/")
+	if i <= 0 {
+		return "/"
+	}
+	return path[:i]
+}
+
+// update applies a change to the stored cookies and writes them back
+func update(change func(data store)) error {
+	data := store{}
+	if err := userdata.Load("cookies", &data); err != nil {
+		return err
+	}
+	change(data)
+
+	// Cookies hold sessions, keep them private to the user
+	return userdata.Save("cookies", data, 0600)
+}
@@ -0,0 +1,189 @@
+package httpfile
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// File is a parsed VS Code REST Client / JetBrains .http file
+type File struct {
+	// File variables declared as `@name = value`, in declaration order
+	Variables []Variable
+	Requests  []Request
+}
+
+type Variable struct {
+	Name  string
+	Value string
+}
+
+type Request struct {
+	// Name given with `# @name`, empty when the request has none
+	Name string
+	// Title written after the ### separator
+	Title   string
+	Method  string
+	URL     string
+	Headers []Header
+	Body    string
+	Line    int
+	// Set with `# @no-cookie-jar`, the request neither sends nor stores cookies
+	NoCookieJar bool
+}
+
+type Header struct {
+	Name  string
+	Value string
+}
+
+var (
+	variableRe    = regexp.MustCompile(`^@([\w.-]+)\s*=\s*(.*)$`)
+	nameRe        = regexp.MustCompile(`^(?:#|//)\s*@name\s+([\w.-]+)`)
+	noCookieJarRe = regexp.MustCompile(`^(?:#|//)\s*@no-cookie-jar\s*$`)
+	requestLineRe = regexp.MustCompile(`^([A-Z]+)\s+(\S+)(?:\s+HTTP/[\d.]+)?$`)
+	// REST Client system variables that have a koi equivalent
+	systemVarRe = regexp.MustCompile(`\{\{\s*\$(\w+)\s*([^}]*)\}\}`)
+)
+
+// Find returns the request with the given name or title
+func (f *File) Find(name string) (Request, bool) {
+	for _, r := range f.Requests {
+		if r.Name == name || r.Title == name {
+			return r, true
+		}
+	}
+	return Request{}, false
+}
+
+// DisplayName returns the name of a request, falling back to its title or request line
+func (r Request) DisplayName() string {
+	switch {
+	case r.Name != "":
+		return r.Name
+	case r.Title != "":
+		return r.Title
+	default:
+		return r.Method + " " + r.URL
+	}
+}
+
+func Parse(data []byte) (*File, error) {
+	f := &File{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+
+	const (
+		stateStart = iota
+		stateHeaders
+		stateBody
+	)
+
+	state := stateStart
+	var cur Request
+	var body []string
+	lineNo := 0
+
+	flush := func() {
+		if cur.Method != "" {
+			cur.Body = strings.TrimSpace(strings.Join(body, "\n"))
+			f.Requests = append(f.Requests, cur)
+		}
+		cur = Request{}
+		body = nil
+		state = stateStart
+	}
+
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimRight(scanner.Text(), "\r")
+		trimmed := strings.TrimSpace(line)
+
+		if strings.HasPrefix(trimmed, "###") {
+			flush()
+			cur.Title = strings.TrimSpace(strings.TrimLeft(trimmed, "#"))
+			continue
+		}
+
+		switch state {
+		case stateStart:
+			if m := nameRe.FindStringSubmatch(trimmed); m != nil {
+				cur.Name = m[1]
+				continue
+			}
+			if noCookieJarRe.MatchString(trimmed) {
+				cur.NoCookieJar = true
+				continue
+			}
+			if trimmed == "" || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, "//") {
+				continue
+			}
+			if m := variableRe.FindStringSubmatch(trimmed); m != nil {
+				f.Variables = append(f.Variables, Variable{Name: m[1], Value: strings.TrimSpace(m[2])})
+				continue
+			}
+
+			if m := requestLineRe.FindStringSubmatch(trimmed); m != nil {
+				cur.Method, cur.URL = m[1], m[2]
+			} else if !strings.Contains(trimmed, " ") {
+				// A bare URL is a GET request
+				cur.Method, cur.URL = "GET", trimmed
+			} else {
+				return nil, fmt.Errorf("line %d: invalid request line %q", lineNo, trimmed)
+			}
+			cur.Line = lineNo
+			state = stateHeaders
+
+		case stateHeaders:
+			if trimmed == "" {
+				state = stateBody
+				continue
+			}
+			if strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, "//") {
+				continue
+			}
+			// Query parameters can continue on the next lines
+			if strings.HasPrefix(trimmed, "?") || strings.HasPrefix(trimmed, "&") {
+				cur.URL += trimmed
+				continue
+			}
+			name, value, ok := strings.Cut(trimmed, ":")
+			if !ok {
+				return nil, fmt.Errorf("line %d: invalid header %q", lineNo, trimmed)
+			}
+			cur.Headers = append(cur.Headers, Header{Name: strings.TrimSpace(name), Value: strings.TrimSpace(value)})
+
+		case stateBody:
+			body = append(body, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading .http file: %w", err)
+	}
+	flush()
+
+	return f, nil
+}
+
+// TranslateSystemVariables rewrites REST Client system variables such as
+// {{$guid}} or {{$processEnv NAME}} into koi template functions
+func TranslateSystemVariables(s string) string {
+	return systemVarRe.ReplaceAllStringFunc(s, func(match string) string {
+		m := systemVarRe.FindStringSubmatch(match)
+		args := strings.TrimSpace(m[2])
+		switch m[1] {
+		case "guid", "uuid", "randomUUID":
+			return "{{uuid}}"
+		case "timestamp":
+			return "{{timestamp}}"
+		case "datetime", "isoTimestamp":
+			return "{{now}}"
+		case "processEnv", "dotenv":
+			return fmt.Sprintf("{{env %q}}", strings.TrimPrefix(args, "%"))
+		default:
+			return match
+		}
+	})
+}
@@ -0,0 +1,379 @@
+package interpolate
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brianvoe/gofakeit/v7"
+	"github.com/killuox/koi/internal/env"
+	"github.com/killuox/koi/internal/utils"
+)
+
+// Matches {{ expression }}
+var placeholderRe = regexp.MustCompile(`\{\{\s*(.*?)\s*\}\}`)
+
+// missing is the value of a lookup that could not be resolved
+type missing struct {
+	name string
+}
+
+type fn func(args []any) (any, error)
+
+var funcs = map[string]fn{
+	"default": func(args []any) (any, error) {
+		if len(args) < 2 {
+			return nil, fmt.Errorf("default expects a fallback and a value")
+		}
+		val := args[len(args)-1]
+		if _, ok := val.(missing); ok || val == nil || val == "" {
+			return args[0], nil
+		}
+		return val, nil
+	},
+	"uuid": func(args []any) (any, error) {
+		return gofakeit.UUID(), nil
+	},
+	"now": func(args []any) (any, error) {
+		return time.Now(), nil
+	},
+	"timestamp": func(args []any) (any, error) {
+		return time.Now().Unix(), nil
+	},
+	"date": func(args []any) (any, error) {
+		if len(args) == 0 {
+			return nil, fmt.Errorf("date expects a layout")
+		}
+		layout := toString(args[0])
+		t := time.Now()
+		if len(args) > 1 {
+			parsed, ok := args[len(args)-1].(time.Time)
+			if !ok {
+				return nil, fmt.Errorf("date expects a time value, got %v", args[len(args)-1])
+			}
+			t = parsed
+		}
+		return t.Format(layout), nil
+	},
+	"base64": func(args []any) (any, error) {
+		return base64.StdEncoding.EncodeToString([]byte(lastString(args))), nil
+	},
+	"base64decode": func(args []any) (any, error) {
+		decoded, err := base64.StdEncoding.DecodeString(lastString(args))
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64 value: %w", err)
+		}
+		return string(decoded), nil
+	},
+	"urlencode": func(args []any) (any, error) {
+		return url.QueryEscape(lastString(args)), nil
+	},
+	"lower": func(args []any) (any, error) {
+		return strings.ToLower(lastString(args)), nil
+	},
+	"upper": func(args []any) (any, error) {
+		return strings.ToUpper(lastString(args)), nil
+	},
+	"trim": func(args []any) (any, error) {
+		return strings.TrimSpace(lastString(args)), nil
+	},
+	// Secrets mounted as files, e.g. {{file "/run/secrets/api_token"}}
+	"file": func(args []any) (any, error) {
+		path := lastString(args)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %w", path, err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	},
+	"env": func(args []any) (any, error) {
+		name := lastString(args)
+		val, exists := env.GetString(name, "")
+		if !exists {
+			return missing{name: "env " + name}, nil
+		}
+		return val, nil
+	},
+}
+
+// Renderer resolves {{placeholders}} against a set of variables and
+// collects the ones it cannot resolve so they can be reported together
+type Renderer struct {
+	vars    map[string]any
+	missing map[string]bool
+	err     error
+	// Variables take precedence over the functions of the same name
+	scoped bool
+}
+
+func New(vars map[string]any) *Renderer {
+	return &Renderer{vars: vars, missing: map[string]bool{}}
+}
+
+// NewScoped returns a renderer for a fixed set of variables, such as the ones
+// of a signature, where {{timestamp}} is the variable and not the function
+func NewScoped(vars map[string]any) *Renderer {
+	r := New(vars)
+	r.scoped = true
+	return r
+}
+
+// Contains reports whether s has at least one placeholder
+func Contains(s string) bool {
+	return placeholderRe.MatchString(s)
+}
+
+// String renders every placeholder in s as text
+func (r *Renderer) String(s string) string {
+	return placeholderRe.ReplaceAllStringFunc(s, func(match string) string {
+		expr := placeholderRe.FindStringSubmatch(match)[1]
+		val, ok := r.eval(expr)
+		if !ok {
+			return match
+		}
+		return toString(val)
+	})
+}
+
+// Value renders placeholders inside v, walking maps and slices. A string made
+// of a single placeholder keeps the type of the resolved value
+func (r *Renderer) Value(v any) any {
+	switch val := v.(type) {
+	case string:
+		loc := placeholderRe.FindStringSubmatchIndex(val)
+		if loc != nil && loc[0] == 0 && loc[1] == len(val) {
+			resolved, ok := r.eval(val[loc[2]:loc[3]])
+			if !ok {
+				return val
+			}
+			if t, isTime := resolved.(time.Time); isTime {
+				return t.Format(time.RFC3339)
+			}
+			return resolved
+		}
+		return r.String(val)
+	case map[string]any:
+		out := make(map[string]any, len(val))
+		for k, item := range val {
+			out[k] = r.Value(item)
+		}
+		return out
+	case map[any]any:
+		out := make(map[string]any, len(val))
+		for k, item := range val {
+			out[fmt.Sprintf("%v", k)] = r.Value(item)
+		}
+		return out
+	case []any:
+		out := make([]any, len(val))
+		for i, item := range val {
+			out[i] = r.Value(item)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// Map renders every value of m
+func (r *Renderer) Map(m map[string]any) map[string]any {
+	if m == nil {
+		return nil
+	}
+	return r.Value(m).(map[string]any)
+}
+
+// StringMap renders every value of m
+func (r *Renderer) StringMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		out[k] = r.String(v)
+	}
+	return out
+}
+
+// Err returns the first evaluation error, or an error listing every
+// placeholder that could not be resolved
+func (r *Renderer) Err() error {
+	if r.err != nil {
+		return r.err
+	}
+	if len(r.missing) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(r.missing))
+	for name := range r.missing {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return fmt.Errorf("unresolved variables: %s", strings.Join(names, ", "))
+}
+
+// eval runs a pipeline such as `token | default "anon"`
+func (r *Renderer) eval(expr string) (any, bool) {
+	stages, err := Tokenize(expr)
+	if err != nil {
+		r.fail(fmt.Errorf("invalid expression {{%s}}: %w", expr, err))
+		return nil, false
+	}
+
+	var val any
+	for i, tokens := range stages {
+		if len(tokens) == 0 {
+			r.fail(fmt.Errorf("invalid expression {{%s}}", expr))
+			return nil, false
+		}
+
+		head := tokens[0]
+		f, isFunc := funcs[head.Text]
+		if _, shadowed := r.vars[head.Text]; isFunc && r.scoped && shadowed {
+			isFunc = false
+		}
+		if head.Quoted || !isFunc {
+			if i > 0 || len(tokens) > 1 {
+				r.fail(fmt.Errorf("unknown function %q in {{%s}}", head.Text, expr))
+				return nil, false
+			}
+			val = r.arg(head)
+			continue
+		}
+
+		args := make([]any, 0, len(tokens))
+		for _, t := range tokens[1:] {
+			args = append(args, r.arg(t))
+		}
+		if i > 0 {
+			args = append(args, val)
+		}
+
+		if head.Text != "default" {
+			if m, ok := firstMissing(args); ok {
+				val = m
+				continue
+			}
+		}
+
+		val, err = f(args)
+		if err != nil {
+			r.fail(fmt.Errorf("error evaluating {{%s}}: %w", expr, err))
+			return nil, false
+		}
+	}
+
+	if m, ok := val.(missing); ok {
+		r.missing[m.name] = true
+		return nil, false
+	}
+	return val, true
+}
+
+func (r *Renderer) arg(t Token) any {
+	if t.Quoted {
+		return t.Text
+	}
+	if n, err := strconv.Atoi(t.Text); err == nil {
+		return n
+	}
+	if f, err := strconv.ParseFloat(t.Text, 64); err == nil {
+		return f
+	}
+	if val, ok := utils.DeepGet(r.vars, t.Text); ok && val != nil {
+		return val
+	}
+	return missing{name: t.Text}
+}
+
+func (r *Renderer) fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+// Token is a word of an expression, Quoted when it was written as a string
+type Token struct {
+	Text   string
+	Quoted bool
+}
+
+// Tokenize splits an expression into pipeline stages of tokens separated by |.
+// Single quoted strings are kept as is and double quoted ones are unquoted
+func Tokenize(s string) ([][]Token, error) {
+	stages := [][]Token{nil}
+	for i := 0; i < len(s); {
+		switch c := s[i]; {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '|':
+			stages = append(stages, nil)
+			i++
+		case c == '"' || c == '\'':
+			end := i + 1
+			for end < len(s) && s[end] != c {
+				if s[end] == '\\' && c == '"' {
+					end++
+				}
+				end++
+			}
+			if end >= len(s) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			text := s[i+1 : end]
+			if c == '"' {
+				unquoted, err := strconv.Unquote(s[i : end+1])
+				if err != nil {
+					return nil, err
+				}
+				text = unquoted
+			}
+			stages[len(stages)-1] = append(stages[len(stages)-1], Token{Text: text, Quoted: true})
+			i = end + 1
+		default:
+			end := i
+			for end < len(s) && !strings.ContainsRune(" \t|\"'", rune(s[end])) {
+				end++
+			}
+			stages[len(stages)-1] = append(stages[len(stages)-1], Token{Text: s[i:end]})
+			i = end
+		}
+	}
+	return stages, nil
+}
+
+func firstMissing(args []any) (missing, bool) {
+	for _, a := range args {
+		if m, ok := a.(missing); ok {
+			return m, true
+		}
+	}
+	return missing{}, false
+}
+
+func lastString(args []any) string {
+	if len(args) == 0 {
+		return ""
+	}
+	return toString(args[len(args)-1])
+}
+
+func toString(v any) string {
+	switch val := v.(type) {
+	case string:
+		return val
+	case time.Time:
+		return val.Format(time.RFC3339)
+	case nil:
+		return ""
+	default:
+		return fmt.Sprintf("%v", val)
+	}
+}
@@ -0,0 +1,28 @@
+package interpolate
+
+import "testing"
+
+func TestFunctionsAndVariables(t *testing.T) {
+	vars := map[string]any{"uuid": "stored", "timestamp": "1440938160", "name": "koi"}
+
+	// Stored variables do not replace the functions
+	r := New(vars)
+	if got := r.String("{{uuid}}"); got == "stored" || len(got) != 36 {
+		t.Errorf("{{uuid}} = %q, want a fresh UUID", got)
+	}
+	if got := r.String("{{timestamp}}"); got == "1440938160" {
+		t.Errorf("{{timestamp}} = %q, want the current time", got)
+	}
+
+	// The variables of a scoped renderer come first
+	r = NewScoped(vars)
+	if got := r.String("{{timestamp}}|{{name | upper}}"); got != "1440938160|KOI" {
+		t.Errorf("scoped = %q, want 1440938160|KOI", got)
+	}
+	if got := r.String("{{now | date \"2006\"}}"); len(got) != 4 {
+		t.Errorf("scoped {{now}} = %q, want the functions of other names", got)
+	}
+	if err := r.Err(); err != nil {
+		t.Error(err)
+	}
+}
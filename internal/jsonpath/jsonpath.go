@@ -0,0 +1,646 @@
+// Package jsonpath evaluates JSONPath expressions (RFC 9535) against decoded
+// JSON values, e.g. $.items[?(@.role=='admin')].id or data.items[-1].id
+package jsonpath
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Path is a compiled JSONPath expression
+type Path struct {
+	segments []segment
+}
+
+// segment selects the children of a node, or of the node and all its
+// descendants for .. segments
+type segment struct {
+	recursive bool
+	selectors []selector
+}
+
+type selector interface {
+	// sel returns the values selected in node, root being the whole document
+	sel(node, root any) []any
+}
+
+// Compile parses a path. The leading $ is optional, so the dot paths of
+// earlier configs such as user.id or items.0.name still work
+func Compile(path string) (*Path, error) {
+	path = strings.TrimSpace(path)
+	switch {
+	case path == "":
+		return nil, fmt.Errorf("empty path")
+	case path[0] == '[':
+		path = "$" + path
+	case path[0] != '$':
+		path = "$." + path
+	}
+
+	p := &parser{s: path}
+	segments, err := p.path()
+	if err == nil && p.i < len(p.s) {
+		err = p.unexpected()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid path %s: %w", path, err)
+	}
+	return &Path{segments: segments}, nil
+}
+
+// Get compiles and evaluates a path
+func Get(data any, path string) (any, bool, error) {
+	p, err := Compile(path)
+	if err != nil {
+		return nil, false, err
+	}
+	val, ok := p.Get(data)
+	return val, ok, nil
+}
+
+// Get returns the value a path selects. Paths with wildcards, slices, unions,
+// filters or .. return the list of every match
+func (p *Path) Get(data any) (any, bool) {
+	matches := eval(p.segments, data, data)
+	if !p.Singular() {
+		return matches, len(matches) > 0
+	}
+	if len(matches) == 0 {
+		return nil, false
+	}
+	return matches[0], true
+}
+
+// Singular reports whether the path selects at most one value
+func (p *Path) Singular() bool {
+	return singular(p.segments)
+}
+
+func singular(segments []segment) bool {
+	for _, s := range segments {
+		if s.recursive || len(s.selectors) != 1 {
+			return false
+		}
+		switch s.selectors[0].(type) {
+		case nameSelector, indexSelector:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func eval(segments []segment, node, root any) []any {
+	nodes := []any{node}
+	for _, s := range segments {
+		var next []any
+		for _, n := range nodes {
+			targets := []any{n}
+			if s.recursive {
+				targets = descendants(n, nil)
+			}
+			for _, t := range targets {
+				for _, sel := range s.selectors {
+					next = append(next, sel.sel(t, root)...)
+				}
+			}
+		}
+		nodes = next
+	}
+	return nodes
+}
+
+// descendants returns a node followed by all its descendants
+func descendants(node any, acc []any) []any {
+	acc = append(acc, node)
+	for _, child := range children(node) {
+		acc = descendants(child, acc)
+	}
+	return acc
+}
+
+// children returns the elements of an array, or the values of an object
+// sorted by key so results are stable
+func children(node any) []any {
+	switch v := node.(type) {
+	case []any:
+		return v
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		values := make([]any, len(keys))
+		for i, k := range keys {
+			values[i] = v[k]
+		}
+		return values
+	}
+	return nil
+}
+
+type nameSelector struct {
+	name string
+	// Dotted names also index arrays, items.0 being items[0]
+	dotted bool
+}
+
+func (s nameSelector) sel(node, _ any) []any {
+	switch v := node.(type) {
+	case map[string]any:
+		if val, ok := v[s.name]; ok {
+			return []any{val}
+		}
+	case []any:
+		if i, err := strconv.Atoi(s.name); err == nil && s.dotted {
+			return indexSelector{i}.sel(node, nil)
+		}
+	}
+	return nil
+}
+
+type indexSelector struct {
+	index int
+}
+
+// Negative indexes count from the end, -1 being the last element
+func (s indexSelector) sel(node, _ any) []any {
+	list, ok := node.([]any)
+	if !ok {
+		return nil
+	}
+	i := s.index
+	if i < 0 {
+		i += len(list)
+	}
+	if i < 0 || i >= len(list) {
+		return nil
+	}
+	return []any{list[i]}
+}
+
+type wildcardSelector struct{}
+
+func (wildcardSelector) sel(node, _ any) []any {
+	return children(node)
+}
+
+type sliceSelector struct {
+	start, end *int
+	step       int
+}
+
+func (s sliceSelector) sel(node, _ any) []any {
+	list, ok := node.([]any)
+	if !ok || s.step == 0 {
+		return nil
+	}
+	n := len(list)
+	bound := func(i *int, def int) int {
+		if i == nil {
+			return def
+		}
+		v := *i
+		if v < 0 {
+			v += n
+		}
+		return v
+	}
+
+	var out []any
+	if s.step > 0 {
+		start, end := max(bound(s.start, 0), 0), min(bound(s.end, n), n)
+		for i := start; i < end; i += s.step {
+			out = append(out, list[i])
+		}
+		return out
+	}
+	start, end := min(bound(s.start, n-1), n-1), max(bound(s.end, -n-1), -1)
+	for i := start; i > end; i += s.step {
+		out = append(out, list[i])
+	}
+	return out
+}
+
+type filterSelector struct {
+	expr expr
+}
+
+func (s filterSelector) sel(node, root any) []any {
+	var out []any
+	for _, child := range children(node) {
+		if truthy(s.expr.eval(child, root)) {
+			out = append(out, child)
+		}
+	}
+	return out
+}
+
+// nothing is the value of a filter path that selects nothing
+type nothing struct{}
+
+type expr interface {
+	eval(cur, root any) any
+}
+
+type literal struct {
+	val any
+}
+
+func (l literal) eval(_, _ any) any {
+	return l.val
+}
+
+// queryExpr is a path inside a filter, from @ (the current node) or $
+type queryExpr struct {
+	fromRoot bool
+	segments []segment
+}
+
+func (q queryExpr) eval(cur, root any) any {
+	node := cur
+	if q.fromRoot {
+		node = root
+	}
+	matches := eval(q.segments, node, root)
+	if len(matches) == 0 {
+		return nothing{}
+	}
+	return matches[0]
+}
+
+type notExpr struct {
+	e expr
+}
+
+func (n notExpr) eval(cur, root any) any {
+	return !truthy(n.e.eval(cur, root))
+}
+
+type logicalExpr struct {
+	and  bool
+	l, r expr
+}
+
+func (l logicalExpr) eval(cur, root any) any {
+	left := truthy(l.l.eval(cur, root))
+	if l.and {
+		return left && truthy(l.r.eval(cur, root))
+	}
+	return left || truthy(l.r.eval(cur, root))
+}
+
+type compareExpr struct {
+	op   string
+	l, r expr
+}
+
+func (c compareExpr) eval(cur, root any) any {
+	l, r := c.l.eval(cur, root), c.r.eval(cur, root)
+	switch c.op {
+	case "==":
+		return equal(l, r)
+	case "!=":
+		return !equal(l, r)
+	}
+
+	if lf, ok := l.(float64); ok {
+		if rf, ok := r.(float64); ok {
+			return compare(c.op, lf < rf, lf == rf)
+		}
+	}
+	if ls, ok := l.(string); ok {
+		if rs, ok := r.(string); ok {
+			return compare(c.op, ls < rs, ls == rs)
+		}
+	}
+	return false
+}
+
+func compare(op string, less, eq bool) bool {
+	switch op {
+	case "<":
+		return less
+	case "<=":
+		return less || eq
+	case ">":
+		return !less && !eq
+	default:
+		return !less
+	}
+}
+
+func equal(a, b any) bool {
+	if ai, ok := a.(int); ok {
+		a = float64(ai)
+	}
+	if bi, ok := b.(int); ok {
+		b = float64(bi)
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// truthy tells whether a filter matches, paths match when they select a value
+func truthy(v any) bool {
+	switch v := v.(type) {
+	case nothing:
+		return false
+	case bool:
+		return v
+	}
+	return true
+}
+
+type parser struct {
+	s string
+	i int
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	return fmt.Errorf("at %d: %s", p.i, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) unexpected() error {
+	if p.i >= len(p.s) {
+		return p.errorf("unexpected end")
+	}
+	return p.errorf("unexpected %q", p.s[p.i])
+}
+
+func (p *parser) peek() byte {
+	if p.i < len(p.s) {
+		return p.s[p.i]
+	}
+	return 0
+}
+
+func (p *parser) skipSpaces() {
+	for p.i < len(p.s) && (p.s[p.i] == ' ' || p.s[p.i] == '\t') {
+		p.i++
+	}
+}
+
+func (p *parser) consume(token string) bool {
+	if strings.HasPrefix(p.s[p.i:], token) {
+		p.i += len(token)
+		return true
+	}
+	return false
+}
+
+// path parses a root ($ or @) followed by its segments
+func (p *parser) path() ([]segment, error) {
+	p.i++ // $ or @
+	var segments []segment
+	for {
+		switch {
+		case p.consume(".."):
+			s, err := p.childSegment()
+			if err != nil {
+				return nil, err
+			}
+			s.recursive = true
+			segments = append(segments, s)
+		case p.consume("."):
+			s, err := p.childSegment()
+			if err != nil {
+				return nil, err
+			}
+			segments = append(segments, s)
+		case p.peek() == '[':
+			s, err := p.bracket()
+			if err != nil {
+				return nil, err
+			}
+			segments = append(segments, s)
+		default:
+			return segments, nil
+		}
+	}
+}
+
+// childSegment parses what follows a dot, a name, * or a bracket after ..
+func (p *parser) childSegment() (segment, error) {
+	switch {
+	case p.consume("*"):
+		return segment{selectors: []selector{wildcardSelector{}}}, nil
+	case p.peek() == '[':
+		return p.bracket()
+	}
+	start := p.i
+	for p.i < len(p.s) && !strings.ContainsRune(".[]()=!<>&|,' \t\"", rune(p.s[p.i])) {
+		p.i++
+	}
+	if p.i == start {
+		return segment{}, p.errorf("expected a name")
+	}
+	return segment{selectors: []selector{nameSelector{name: p.s[start:p.i], dotted: true}}}, nil
+}
+
+// bracket parses [selector, ...], selectors being names, indexes, slices,
+// * or ?filters
+func (p *parser) bracket() (segment, error) {
+	p.i++ // [
+	var s segment
+	for {
+		p.skipSpaces()
+		sel, err := p.selector()
+		if err != nil {
+			return s, err
+		}
+		s.selectors = append(s.selectors, sel)
+		p.skipSpaces()
+		if p.consume("]") {
+			return s, nil
+		}
+		if !p.consume(",") {
+			return s, p.errorf("expected , or ]")
+		}
+	}
+}
+
+func (p *parser) selector() (selector, error) {
+	switch c := p.peek(); {
+	case c == '*':
+		p.i++
+		return wildcardSelector{}, nil
+	case c == '\'' || c == '"':
+		name, err := p.quoted()
+		return nameSelector{name: name}, err
+	case c == '?':
+		p.i++
+		p.skipSpaces()
+		e, err := p.or()
+		return filterSelector{expr: e}, err
+	case c == '-' || c == ':' || c >= '0' && c <= '9':
+		return p.indexOrSlice()
+	}
+	return nil, p.unexpected()
+}
+
+func (p *parser) indexOrSlice() (selector, error) {
+	var bounds [3]*int
+	part := 0
+	for {
+		p.skipSpaces()
+		if c := p.peek(); c == '-' || c >= '0' && c <= '9' {
+			n, err := p.integer()
+			if err != nil {
+				return nil, err
+			}
+			bounds[part] = &n
+		}
+		p.skipSpaces()
+		if part == 2 || !p.consume(":") {
+			break
+		}
+		part++
+	}
+
+	if part == 0 {
+		if bounds[0] == nil {
+			return nil, p.errorf("expected an index")
+		}
+		return indexSelector{*bounds[0]}, nil
+	}
+	step := 1
+	if bounds[2] != nil {
+		step = *bounds[2]
+	}
+	return sliceSelector{start: bounds[0], end: bounds[1], step: step}, nil
+}
+
+func (p *parser) integer() (int, error) {
+	start := p.i
+	if p.peek() == '-' {
+		p.i++
+	}
+	for p.i < len(p.s) && p.s[p.i] >= '0' && p.s[p.i] <= '9' {
+		p.i++
+	}
+	n, err := strconv.Atoi(p.s[start:p.i])
+	if err != nil {
+		return 0, p.errorf("invalid number %q", p.s[start:p.i])
+	}
+	return n, nil
+}
+
+func (p *parser) quoted() (string, error) {
+	quote := p.s[p.i]
+	p.i++
+	var sb strings.Builder
+	for p.i < len(p.s) && p.s[p.i] != quote {
+		if p.s[p.i] == '\\' && p.i+1 < len(p.s) {
+			p.i++
+		}
+		sb.WriteByte(p.s[p.i])
+		p.i++
+	}
+	if p.i >= len(p.s) {
+		return "", p.errorf("unterminated string")
+	}
+	p.i++
+	return sb.String(), nil
+}
+
+func (p *parser) or() (expr, error) {
+	l, err := p.and()
+	for err == nil {
+		p.skipSpaces()
+		if !p.consume("||") {
+			break
+		}
+		var r expr
+		if r, err = p.and(); err == nil {
+			l = logicalExpr{l: l, r: r}
+		}
+	}
+	return l, err
+}
+
+func (p *parser) and() (expr, error) {
+	l, err := p.unary()
+	for err == nil {
+		p.skipSpaces()
+		if !p.consume("&&") {
+			break
+		}
+		var r expr
+		if r, err = p.unary(); err == nil {
+			l = logicalExpr{and: true, l: l, r: r}
+		}
+	}
+	return l, err
+}
+
+func (p *parser) unary() (expr, error) {
+	p.skipSpaces()
+	switch {
+	case p.peek() == '!' && !strings.HasPrefix(p.s[p.i:], "!="):
+		p.i++
+		e, err := p.unary()
+		return notExpr{e}, err
+	case p.consume("("):
+		e, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpaces()
+		if !p.consume(")") {
+			return nil, p.errorf("expected )")
+		}
+		return e, nil
+	}
+	return p.comparison()
+}
+
+func (p *parser) comparison() (expr, error) {
+	l, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpaces()
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if p.consume(op) {
+			r, err := p.operand()
+			return compareExpr{op: op, l: l, r: r}, err
+		}
+	}
+	return l, nil
+}
+
+func (p *parser) operand() (expr, error) {
+	p.skipSpaces()
+	switch c := p.peek(); {
+	case c == '@' || c == '$':
+		segments, err := p.path()
+		return queryExpr{fromRoot: c == '$', segments: segments}, err
+	case c == '\'' || c == '"':
+		s, err := p.quoted()
+		return literal{s}, err
+	case c == '-' || c >= '0' && c <= '9':
+		start := p.i
+		p.i++
+		for p.i < len(p.s) && strings.ContainsRune("0123456789.eE+-", rune(p.s[p.i])) {
+			p.i++
+		}
+		f, err := strconv.ParseFloat(p.s[start:p.i], 64)
+		if err != nil {
+			return nil, p.errorf("invalid number %q", p.s[start:p.i])
+		}
+		return literal{f}, nil
+	}
+	for _, kw := range []struct {
+		word string
+		val  any
+	}{{"true", true}, {"false", false}, {"null", nil}} {
+		if p.consume(kw.word) {
+			return literal{kw.val}, nil
+		}
+	}
+	return nil, p.unexpected()
+}
@@ -0,0 +1,115 @@
+package jsonpath
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+const doc = `{
+	"users": [
+		{"id": 1, "name": "ana", "role": "admin", "age": 31},
+		{"id": 2, "name": "bob", "role": "user", "age": 17},
+		{"id": 3, "name": "cid", "role": "admin", "age": 45, "email": "cid@example.com"}
+	],
+	"items": [{"name": "first"}, {"name": "second"}],
+	"limit": 2,
+	"meta": {"total": 3, "next": null, "dotted.key": "yes"}
+}`
+
+func TestGet(t *testing.T) {
+	var data any
+	if err := json.Unmarshal([]byte(doc), &data); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		path string
+		// want is JSON, ignored when ok is false
+		want string
+		ok   bool
+	}{
+		{name: "legacy dot path", path: "meta.total", want: `3`, ok: true},
+		{name: "legacy dot path with index", path: "items.0.name", want: `"first"`, ok: true},
+		{name: "legacy dot path out of range", path: "items.5.name", ok: false},
+		{name: "root", path: "$", want: doc, ok: true},
+		{name: "child", path: "$.users[1].name", want: `"bob"`, ok: true},
+		{name: "bracket name", path: "$.meta['dotted.key']", want: `"yes"`, ok: true},
+		{name: "leading bracket", path: "['limit']", want: `2`, ok: true},
+		{name: "null value", path: "$.meta.next", want: `null`, ok: true},
+		{name: "missing", path: "$.meta.prev", ok: false},
+		{name: "dot index is a name in strict paths", path: "$.items['0']", ok: false},
+
+		{name: "negative index", path: "$.users[-1].name", want: `"cid"`, ok: true},
+		{name: "negative index in dot path", path: "users.-2.id", want: `2`, ok: true},
+		{name: "negative index out of range", path: "$.users[-4]", ok: false},
+
+		{name: "slice", path: "$.users[0:2].id", want: `[1, 2]`, ok: true},
+		{name: "slice open end", path: "$.users[1:].id", want: `[2, 3]`, ok: true},
+		{name: "slice negative start", path: "$.users[-2:].id", want: `[2, 3]`, ok: true},
+		{name: "slice step", path: "$.users[::2].id", want: `[1, 3]`, ok: true},
+		{name: "slice reversed", path: "$.users[::-1].id", want: `[3, 2, 1]`, ok: true},
+		{name: "empty slice", path: "$.users[2:1]", ok: false},
+
+		{name: "wildcard", path: "$.users[*].name", want: `["ana", "bob", "cid"]`, ok: true},
+		{name: "union", path: "$.users[0,2].id", want: `[1, 3]`, ok: true},
+		{name: "descendants", path: "$..email", want: `["cid@example.com"]`, ok: true},
+		{name: "descendant names", path: "$..name", want: `["first", "second", "ana", "bob", "cid"]`, ok: true},
+		{name: "descendant index", path: "$..[0].name", want: `["first", "ana"]`, ok: true},
+
+		{name: "filter equal", path: "$.users[?(@.role=='admin')].id", want: `[1, 3]`, ok: true},
+		{name: "filter without parentheses", path: `$.users[?@.name == "bob"].id`, want: `[2]`, ok: true},
+		{name: "filter comparison", path: "$.users[?(@.age >= 18 && @.age < 40)].name", want: `["ana"]`, ok: true},
+		{name: "filter or", path: "$.users[?(@.age < 18 || @.age > 40)].id", want: `[2, 3]`, ok: true},
+		{name: "filter existence", path: "$.users[?(@.email)].id", want: `[3]`, ok: true},
+		{name: "filter not", path: "$.users[?(!@.email)].id", want: `[1, 2]`, ok: true},
+		{name: "filter against root", path: "$.users[?(@.id == $.limit)].name", want: `["bob"]`, ok: true},
+		{name: "filter without matches", path: "$.users[?(@.role=='guest')]", ok: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok, err := Get(data, tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != tt.ok {
+				t.Fatalf("Get(%s) ok = %v, want %v (value %v)", tt.path, ok, tt.ok, got)
+			}
+			if !tt.ok {
+				return
+			}
+			var want any
+			if err := json.Unmarshal([]byte(tt.want), &want); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Get(%s) = %v, want %v", tt.path, got, want)
+			}
+		})
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+	}{
+		{name: "empty", path: ""},
+		{name: "unclosed bracket", path: "$.users[0"},
+		{name: "unterminated string", path: "$['users"},
+		{name: "missing name", path: "$.users."},
+		{name: "bad selector", path: "$.users[#]"},
+		{name: "unclosed filter", path: "$.users[?(@.id == 1]"},
+		{name: "trailing text", path: "$.users]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Compile(tt.path); err == nil {
+				t.Errorf("Compile(%q) succeeded, want an error", tt.path)
+			}
+		})
+	}
+}
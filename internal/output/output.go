@@ -1,8 +1,12 @@
 package output
 
 import (
+	"crypto/tls"
 	"fmt"
+	"net/http"
 	"os"
+	"sort"
+	"strings"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/killuox/koi/internal/api"
@@ -20,8 +24,23 @@ func ShowResponse(r api.Result) {
 
 	title := fmt.Sprintf("%s%v%s • %s %s • %vms",
 		colorCode, r.Status, ColorReset, r.Method, r.Url, r.Duration.Milliseconds())
+	if r.Env != "" {
+		title = fmt.Sprintf("%s • %s", r.Env, title)
+	}
+	if r.TLS != nil {
+		title += "\n" + formatTLS(r.TLS)
+	}
+	// HEAD responses have no body, their headers are the response
+	content := string(r.Body)
+	if r.Method == http.MethodHead {
+		content = formatHeaders(r.Headers)
+	}
+	if len(r.Redirects) > 0 {
+		content = formatRedirects(r.Redirects) + "\n" + content
+	}
+
 	p := tea.NewProgram(
-		Pager{content: string(r.Body), title: title},
+		Pager{content: content, title: title},
 		tea.WithAltScreen(),       // use the full size of the terminal in its "alternate screen buffer"
 		tea.WithMouseCellMotion(), // turn on mouse support so we can track the mouse wheel
 	)
@@ -32,6 +51,47 @@ func ShowResponse(r api.Result) {
 	}
 }
 
+// formatHeaders lists the headers as Name: value lines, sorted by name
+func formatHeaders(h http.Header) string {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	for _, name := range names {
+		for _, val := range h[name] {
+			fmt.Fprintf(&sb, "%s: %s\n", name, val)
+		}
+	}
+	return sb.String()
+}
+
+// formatTLS describes the negotiated TLS version and cipher, and the server certificate
+func formatTLS(state *tls.ConnectionState) string {
+	parts := []string{tls.VersionName(state.Version), tls.CipherSuiteName(state.CipherSuite)}
+	if len(state.PeerCertificates) > 0 {
+		cert := state.PeerCertificates[0]
+		subject := cert.Subject.CommonName
+		if subject == "" {
+			subject = cert.Subject.String()
+		}
+		parts = append(parts, subject, "expires "+cert.NotAfter.Format("2006-01-02"))
+	}
+	return strings.Join(parts, " • ")
+}
+
+// formatRedirects lists the responses that led to the final one
+func formatRedirects(redirects []api.Redirect) string {
+	var sb strings.Builder
+	sb.WriteString("Redirects:\n")
+	for _, rd := range redirects {
+		fmt.Fprintf(&sb, "  %s%d%s %s\n", getColorForStatus(rd.Status), rd.Status, ColorReset, rd.Url)
+	}
+	return sb.String()
+}
+
 func getColorForStatus(status int) string {
 	if status >= 200 && status <= 299 {
 		return ColorGreen
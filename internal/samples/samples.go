@@ -0,0 +1,75 @@
+package samples
+
+import (
+	"mime"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/killuox/koi/internal/userdata"
+)
+
+// Larger bodies are not recorded, they make poor examples
+const maxBodySize = 1 << 20
+
+// Sample is the last response recorded for an endpoint
+type Sample struct {
+	Status      int    `json:"status"`
+	ContentType string `json:"contentType,omitempty"`
+	Body        string `json:"body"`
+}
+
+// Samples are grouped by config file so projects don't share them
+type store map[string]map[string]Sample
+
+// Save records the response of an endpoint, unless its body is binary or too large
+func Save(configPath, endpoint string, s Sample) error {
+	if len(s.Body) > maxBodySize || !isText(s.ContentType, s.Body) {
+		return nil
+	}
+
+	data := store{}
+	if err := userdata.Load("samples", &data); err != nil {
+		return err
+	}
+
+	key, err := userdata.ProjectKey(configPath)
+	if err != nil {
+		return err
+	}
+	if data[key] == nil {
+		data[key] = map[string]Sample{}
+	}
+	data[key][endpoint] = s
+
+	// Responses may hold tokens or personal data, keep them private to the user
+	return userdata.Save("samples", data, 0600)
+}
+
+// isText tells whether a body is text, by its content type or its content
+// when there is none
+func isText(contentType, body string) bool {
+	if !utf8.ValidString(body) {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if contentType == "" || err != nil {
+		return true
+	}
+	return strings.HasPrefix(mediaType, "text/") ||
+		strings.HasSuffix(mediaType, "json") || strings.HasSuffix(mediaType, "xml") ||
+		mediaType == "application/javascript" || mediaType == "application/x-www-form-urlencoded"
+}
+
+// GetAll returns the samples recorded for a config file, keyed by endpoint name
+func GetAll(configPath string) (map[string]Sample, error) {
+	data := store{}
+	if err := userdata.Load("samples", &data); err != nil {
+		return nil, err
+	}
+
+	key, err := userdata.ProjectKey(configPath)
+	if err != nil {
+		return nil, err
+	}
+	return data[key], nil
+}
@@ -1,9 +1,22 @@
 package shared
 
-import "github.com/killuox/koi/internal/config"
+import (
+	"github.com/killuox/koi/internal/config"
+	"github.com/killuox/koi/internal/cookies"
+)
 
 type State struct {
 	Cfg       config.Config
 	Flags     map[string]interface{}
 	Variables map[string]interface{}
+	// Send values breaking their parameter type or rules as is
+	NoValidate bool
+	// Raw body given with --data, sent instead of the body parameters
+	Data []byte
+	// Merge Data over the body parameters instead of replacing them
+	MergeData bool
+	// Record the response as an example for exports
+	Record bool
+	// Cookies kept between invocations, nil when there is no config
+	Jar *cookies.Jar
 }
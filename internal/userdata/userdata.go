@@ -0,0 +1,83 @@
+// Package userdata reads and writes the JSON files koi keeps under ~/.koi/
+package userdata
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Path returns the path of ~/.koi/<name>.json, creating the directory
+func Path(name string) (string, error) {
+	// Get home directory
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("error finding home directory: %s", err)
+	}
+
+	dirPath := filepath.Join(home, ".koi")
+	filePath := filepath.Join(dirPath, name+".json")
+
+	// Ensure directory exists
+	if _, err := os.Stat(dirPath); os.IsNotExist(err) {
+		if err := os.MkdirAll(dirPath, 0755); err != nil {
+			return "", fmt.Errorf("error creating directory: %s", err)
+		}
+	}
+
+	return filePath, nil
+}
+
+// Load decodes ~/.koi/<name>.json into v, which is left as is when the file
+// is missing or empty
+func Load(name string, v any) error {
+	filePath, err := Path(name)
+	if err != nil {
+		return err
+	}
+
+	raw, err := os.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading file: %w", err)
+	}
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, v); err != nil {
+			return fmt.Errorf("error parsing JSON: %w", err)
+		}
+	}
+	return nil
+}
+
+// Save writes v to ~/.koi/<name>.json with the permissions perm, which also
+// apply to a file written before with other ones
+func Save(name string, v any, perm os.FileMode) error {
+	updated, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling %s: %w", name, err)
+	}
+
+	filePath, err := Path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filePath, updated, perm); err != nil {
+		return fmt.Errorf("error writing file: %w", err)
+	}
+	if err := os.Chmod(filePath, perm); err != nil {
+		return fmt.Errorf("error writing file: %w", err)
+	}
+	return nil
+}
+
+// ProjectKey identifies a config file, so projects don't share what is stored
+func ProjectKey(configPath string) (string, error) {
+	abs, err := filepath.Abs(configPath)
+	if err != nil {
+		return "", fmt.Errorf("error resolving %s: %w", configPath, err)
+	}
+	return abs, nil
+}
@@ -0,0 +1,60 @@
+package userdata
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSaveAndLoad(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	data := map[string]any{"kept": true}
+	if err := Load("things", &data); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(data, map[string]any{"kept": true}) {
+		t.Errorf("missing file loaded %v, want the value unchanged", data)
+	}
+
+	// A file readable by everyone is made private
+	path := filepath.Join(home, ".koi", "things.json")
+	if err := os.WriteFile(path, []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{"token": "abc"}
+	if err := Save("things", want, 0600); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	got := map[string]any{}
+	if err := Load("things", &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("loaded %v, want %v", got, want)
+	}
+}
+
+func TestProjectKey(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ProjectKey("koi.config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(wd, "koi.config.yaml"); key != want {
+		t.Errorf("key = %q, want %q", key, want)
+	}
+}